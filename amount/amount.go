@@ -0,0 +1,159 @@
+package amount
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DECIMALS is how many decimal places one coin divides into.
+	DECIMALS = 8
+
+	COIN Amount = 100000000
+
+	MAX Amount = math.MaxUint64
+)
+
+var (
+	ErrOverflow  = errors.New("amount overflow")
+	ErrUnderflow = errors.New("amount underflow")
+	ErrSyntax    = errors.New("invalid amount")
+)
+
+// Amount counts the smallest unit, 1/COIN of a coin. Amounts are never
+// negative and all arithmetic on them is checked.
+type Amount uint64
+
+// Parse reads a non-negative decimal such as "12", "0.5" or "3.14159265".
+// More than DECIMALS fractional digits is an error rather than rounding.
+func Parse(s string) (Amount, error) {
+	if s == "" {
+		return 0, fmt.Errorf("%w: empty", ErrSyntax)
+	}
+
+	whole, frac := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		whole, frac = s[:i], s[i+1:]
+		if frac == "" {
+			return 0, fmt.Errorf("%w: %q", ErrSyntax, s)
+		}
+	}
+	if whole == "" {
+		whole = "0"
+	}
+
+	if len(frac) > DECIMALS {
+		return 0, fmt.Errorf("%w: %q has more than %d decimals", ErrSyntax, s, DECIMALS)
+	}
+	if !isDigits(whole) || !isDigits(frac) {
+		return 0, fmt.Errorf("%w: %q", ErrSyntax, s)
+	}
+
+	w, err := strconv.ParseUint(whole, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q", ErrOverflow, s)
+	}
+	units, err := Amount(w).Mul(COIN)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q", ErrOverflow, s)
+	}
+
+	var f uint64 = 0
+	if frac != "" {
+		frac += strings.Repeat("0", DECIMALS-len(frac))
+		f, _ = strconv.ParseUint(frac, 10, 64)
+	}
+	return units.Add(Amount(f))
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// String formats the amount with trailing fractional zeros removed, e.g.
+// "1", "0.5". It is the canonical form used in signed payloads.
+func (a Amount) String() string {
+	whole := uint64(a / COIN)
+	frac := uint64(a % COIN)
+	if frac == 0 {
+		return strconv.FormatUint(whole, 10)
+	}
+
+	f := fmt.Sprintf("%0*d", DECIMALS, frac)
+	return strconv.FormatUint(whole, 10) + "." + strings.TrimRight(f, "0")
+}
+
+func (a Amount) Add(b Amount) (Amount, error) {
+	if a > MAX-b {
+		return 0, ErrOverflow
+	}
+	return a + b, nil
+}
+
+func (a Amount) Sub(b Amount) (Amount, error) {
+	if b > a {
+		return 0, ErrUnderflow
+	}
+	return a - b, nil
+}
+
+func (a Amount) Mul(n Amount) (Amount, error) {
+	if n != 0 && a > MAX/n {
+		return 0, ErrOverflow
+	}
+	return a * n, nil
+}
+
+// Sum adds amounts, failing on overflow.
+func Sum(amounts ...Amount) (Amount, error) {
+	var total Amount = 0
+	for _, a := range amounts {
+		var err error
+		if total, err = total.Add(a); err != nil {
+			return 0, err
+		}
+	}
+	return total, nil
+}
+
+// MarshalJSON writes the amount as a decimal string so no float conversion
+// happens on the way through JSON.
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.String())
+}
+
+// UnmarshalJSON accepts the decimal string form and, for data written
+// before amounts were fixed-point, a plain JSON number. The number is read
+// from its literal text, never through a float.
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		v, err := Parse(s)
+		if err != nil {
+			return err
+		}
+		*a = v
+		return nil
+	}
+
+	v, err := Parse(string(data))
+	if err != nil {
+		return err
+	}
+	*a = v
+	return nil
+}
@@ -1,54 +1,65 @@
 package block
 
 import (
-	"crypto/sha256"
 	"encoding/json"
-	"fmt"
-	"time"
+	"errors"
 
 	"github.com/Ethical-Ralph/go-block/transaction"
 )
 
 type Block struct {
-	Timestamp    int64
-	Nonce        int
-	PreviousHash [32]byte
+	Header       *Header
 	Transactions []*transaction.Transaction
 }
 
-func NewBlock(nonce int, previousHash [32]byte, transactions []*transaction.Transaction) *Block {
+// NewBlock pairs a solved header with the transactions it commits to.
+func NewBlock(header *Header, transactions []*transaction.Transaction) *Block {
 	b := new(Block)
-	b.Nonce = nonce
-	b.PreviousHash = previousHash
-	b.Timestamp = time.Now().UnixNano()
+	b.Header = header
 	b.Transactions = transactions
 	return b
 }
 
 func (b *Block) Print() {
-	fmt.Printf("timestamp           %d\n", b.Timestamp)
-	fmt.Printf("nonce               %d\n", b.Nonce)
-	fmt.Printf("previousHash        %x\n", b.PreviousHash)
+	b.Header.Print()
 	for _, t := range b.Transactions {
 		t.Print()
 	}
 }
 
+// CalculateHash is the hash of the block header.
 func (b *Block) CalculateHash() [32]byte {
-	jsonString, _ := b.MarshalJSON()
-	return sha256.Sum256([]byte(jsonString))
+	return b.Header.Hash()
 }
 
 func (b *Block) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
-		Timestamp    int64                      `json:"timestamp"`
-		Nonce        int                        `json:"nonce"`
-		PreviousHash string                     `json:"previousHash"`
+		Header       *Header                    `json:"header"`
 		Transactions []*transaction.Transaction `json:"transactions"`
 	}{
-		Timestamp:    b.Timestamp,
-		Nonce:        b.Nonce,
-		PreviousHash: fmt.Sprintf("%x", b.PreviousHash),
+		Header:       b.Header,
 		Transactions: b.Transactions,
 	})
 }
+
+func (b *Block) UnmarshalJSON(data []byte) error {
+	v := &struct {
+		Header       **Header                    `json:"header"`
+		Transactions *[]*transaction.Transaction `json:"transactions"`
+	}{
+		Header:       &b.Header,
+		Transactions: &b.Transactions,
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return err
+	}
+	if b.Header == nil {
+		return errors.New("block has no header")
+	}
+	for _, t := range b.Transactions {
+		if t == nil {
+			return errors.New("block has an empty transaction")
+		}
+	}
+	return nil
+}
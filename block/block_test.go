@@ -0,0 +1,42 @@
+package block
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Ethical-Ralph/go-block/transaction"
+)
+
+func TestUnmarshalJSON(t *testing.T) {
+	transactions := []*transaction.Transaction{transaction.NewTransaction("THE BLOCKCHAIN", "miner", 1, 0, 1, 1)}
+	m, err := json.Marshal(NewBlock(NewHeader(1, [32]byte{}, transactions, 0), transactions))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := new(Block)
+	if err := json.Unmarshal(m, b); err != nil {
+		t.Fatal(err)
+	}
+	if b.Header.MerkleRoot != MerkleRoot(b.Transactions) {
+		t.Error("decoded transactions do not match the merkle root")
+	}
+
+	for _, data := range []string{
+		`null`,
+		`{"transactions":[]}`,
+		`{"header":` + string(mustMarshal(t, b.Header)) + `,"transactions":[null]}`,
+	} {
+		if err := json.Unmarshal([]byte(data), new(Block)); err == nil {
+			t.Errorf("Unmarshal(%s) accepted", data)
+		}
+	}
+}
+
+func mustMarshal(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	m, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
@@ -0,0 +1,140 @@
+package block
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Ethical-Ralph/go-block/merkle"
+	"github.com/Ethical-Ralph/go-block/transaction"
+)
+
+const BLOCK_VERSION = 1
+
+// Header is the part of a block the proof of work is computed on. It
+// commits to the transactions through MerkleRoot, so the block hash is the
+// header hash.
+type Header struct {
+	Version    uint32
+	Height     uint64
+	PrevHash   [32]byte
+	MerkleRoot [32]byte
+	Timestamp  int64
+	Difficulty int
+	Nonce      uint64
+}
+
+// NewHeader returns the unsolved header of a block of transactions on top of
+// prevHash. The timestamp is set here, once, so the nonce search and the
+// appended block hash the same header.
+func NewHeader(height uint64, prevHash [32]byte, transactions []*transaction.Transaction, difficulty int) *Header {
+	return &Header{
+		Version:    BLOCK_VERSION,
+		Height:     height,
+		PrevHash:   prevHash,
+		MerkleRoot: MerkleRoot(transactions),
+		Timestamp:  time.Now().UnixNano(),
+		Difficulty: difficulty,
+	}
+}
+
+// MerkleRoot is the merkle root over the hashes of transactions, in order.
+func MerkleRoot(transactions []*transaction.Transaction) [32]byte {
+	return merkle.Root(TransactionHashes(transactions))
+}
+
+// TransactionHashes are the leaves of the merkle tree of transactions.
+func TransactionHashes(transactions []*transaction.Transaction) [][32]byte {
+	hashes := make([][32]byte, len(transactions))
+	for i, t := range transactions {
+		hashes[i] = t.Hash()
+	}
+	return hashes
+}
+
+// Hash is the SHA-256 of the header's fixed-size big-endian encoding.
+func (h *Header) Hash() [32]byte {
+	var buf [96]byte
+	binary.BigEndian.PutUint32(buf[0:], h.Version)
+	binary.BigEndian.PutUint64(buf[4:], h.Height)
+	copy(buf[12:], h.PrevHash[:])
+	copy(buf[44:], h.MerkleRoot[:])
+	binary.BigEndian.PutUint64(buf[76:], uint64(h.Timestamp))
+	binary.BigEndian.PutUint32(buf[84:], uint32(h.Difficulty))
+	binary.BigEndian.PutUint64(buf[88:], h.Nonce)
+	return sha256.Sum256(buf[:])
+}
+
+func (h *Header) Print() {
+	fmt.Printf("version             %d\n", h.Version)
+	fmt.Printf("height              %d\n", h.Height)
+	fmt.Printf("previousHash        %x\n", h.PrevHash)
+	fmt.Printf("merkleRoot          %x\n", h.MerkleRoot)
+	fmt.Printf("timestamp           %d\n", h.Timestamp)
+	fmt.Printf("difficulty          %d\n", h.Difficulty)
+	fmt.Printf("nonce               %d\n", h.Nonce)
+}
+
+func (h *Header) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Version    uint32 `json:"version"`
+		Height     uint64 `json:"height"`
+		PrevHash   string `json:"previousHash"`
+		MerkleRoot string `json:"merkleRoot"`
+		Timestamp  int64  `json:"timestamp"`
+		Difficulty int    `json:"difficulty"`
+		Nonce      uint64 `json:"nonce"`
+	}{
+		Version:    h.Version,
+		Height:     h.Height,
+		PrevHash:   fmt.Sprintf("%x", h.PrevHash),
+		MerkleRoot: fmt.Sprintf("%x", h.MerkleRoot),
+		Timestamp:  h.Timestamp,
+		Difficulty: h.Difficulty,
+		Nonce:      h.Nonce,
+	})
+}
+
+func (h *Header) UnmarshalJSON(data []byte) error {
+	var prevHash, merkleRoot string
+	v := &struct {
+		Version    *uint32 `json:"version"`
+		Height     *uint64 `json:"height"`
+		PrevHash   *string `json:"previousHash"`
+		MerkleRoot *string `json:"merkleRoot"`
+		Timestamp  *int64  `json:"timestamp"`
+		Difficulty *int    `json:"difficulty"`
+		Nonce      *uint64 `json:"nonce"`
+	}{
+		Version:    &h.Version,
+		Height:     &h.Height,
+		PrevHash:   &prevHash,
+		MerkleRoot: &merkleRoot,
+		Timestamp:  &h.Timestamp,
+		Difficulty: &h.Difficulty,
+		Nonce:      &h.Nonce,
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return err
+	}
+
+	if err := decodeHash(&h.PrevHash, prevHash, "previousHash"); err != nil {
+		return err
+	}
+	return decodeHash(&h.MerkleRoot, merkleRoot, "merkleRoot")
+}
+
+func decodeHash(dst *[32]byte, s string, name string) error {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	if len(b) != len(dst) {
+		return fmt.Errorf("invalid %s length %d", name, len(b))
+	}
+	copy(dst[:], b)
+	return nil
+}
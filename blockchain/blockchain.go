@@ -1,24 +1,33 @@
 package blockchain
 
 import (
+	"bytes"
 	"crypto/ecdsa"
 	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/Ethical-Ralph/go-block/amount"
 	"github.com/Ethical-Ralph/go-block/block"
+	"github.com/Ethical-Ralph/go-block/mempool"
+	"github.com/Ethical-Ralph/go-block/merkle"
+	"github.com/Ethical-Ralph/go-block/store"
 	"github.com/Ethical-Ralph/go-block/transaction"
 	"github.com/Ethical-Ralph/go-block/utils"
+	"github.com/Ethical-Ralph/go-block/utxo"
+	"github.com/Ethical-Ralph/go-block/wallet"
 )
 
 const (
-	MINNING_DIFFICULTY = 3
+	MINNING_DIFFICULTY = 12
 	MINNING_SENDER     = "THE BLOCKCHAIN"
-	MINNING_REWARD     = 1
+	MINNING_REWARD     = 1 * amount.COIN
 	MINING_TIMER_SEC   = 20
 
 	BLOCKCHAIN_PORT_RANGE_START = 5000
@@ -28,37 +37,129 @@ const (
 	NIEGHBOR_IP_RANGE_END   = 1
 
 	BLOCKCHAIN_NEIGHBOR_SYNC_TIME = 20
+
+	NEIGHBOR_REQUEST_TIMEOUT = 5 * time.Second
+
+	MEMPOOL_MAX_TRANSACTIONS = 5000
+	MEMPOOL_MAX_BYTES        = 4 << 20
+	MEMPOOL_TTL              = 3 * time.Hour
+
+	// Defaults for what the miner puts in one block, coinbase included.
+	BLOCK_MAX_TRANSACTIONS = 1000
+	BLOCK_MAX_BYTES        = 1 << 20
+)
+
+var (
+	ErrTransactionSeen    = errors.New("transaction already seen")
+	ErrInvalidSignature   = errors.New("invalid transaction signature")
+	ErrSenderMismatch     = errors.New("sender address does not belong to the signing key")
+	ErrInvalidNonce       = errors.New("transaction nonce out of order")
+	ErrUnknownTransaction = errors.New("transaction not on the chain")
+	ErrInvalidBlock       = errors.New("invalid block")
+	ErrUnknownParent      = errors.New("parent block not known")
 )
 
 type Blockchain struct {
-	transactionPool   []*transaction.Transaction
+	mempool *mempool.Mempool
+	// seenTransactions holds the IDs of transactions on the chain.
+	seenTransactions  map[[32]byte]bool
+	confirmedNonces   map[string]uint64
+	utxos             *utxo.Set
+	undo              []*utxo.Undo
 	chain             []*block.Block
+	store             store.Store
 	blockchainAddress string
 	port              uint16
 	mux               sync.Mutex
+	muxPool           sync.Mutex
+
+	maxBlockTransactions int
+	maxBlockBytes        int
+	targetInterval       time.Duration
+	mineEmptyBlocks      bool
+
+	// blocks is the block tree: the main chain and every side branch seen.
+	blocks        map[[32]byte]*treeNode
+	invalidBlocks map[[32]byte]bool
+	reorgs        []*ReorgEvent
+
+	syncProgress SyncProgress
+	muxSync      sync.Mutex
 
 	neighbors    []string
 	muxNeighbors sync.Mutex
 }
 
-func NewBlockchain(blockchainAddress string, port uint16) *Blockchain {
-	b := &block.Block{}
+// NewBlockchain loads the chain persisted in s, validating it before use. An
+// empty store gets a fresh genesis block. targetInterval is the block
+// interval difficulty is retargeted towards; it is a consensus rule, so it
+// is needed to validate the stored chain and cannot change afterwards.
+func NewBlockchain(blockchainAddress string, port uint16, s store.Store, targetInterval time.Duration) (*Blockchain, error) {
 	bc := new(Blockchain)
 	bc.blockchainAddress = blockchainAddress
 	bc.port = port
-	bc.CreateBlock(0, b.CalculateHash())
-	return bc
+	bc.store = s
+	bc.maxBlockTransactions = BLOCK_MAX_TRANSACTIONS
+	bc.maxBlockBytes = BLOCK_MAX_BYTES
+	bc.targetInterval = targetInterval
+	bc.mineEmptyBlocks = true
+	bc.syncProgress = SyncProgress{State: SYNC_STATE_IDLE}
+	bc.mempool = mempool.NewMempool(MEMPOOL_MAX_TRANSACTIONS, MEMPOOL_MAX_BYTES, MEMPOOL_TTL)
+	chain, err := s.Blocks()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(chain) == 0 {
+		bc.indexChain(nil)
+		if _, err := bc.CreateBlock(block.NewBlock(block.NewHeader(0, [32]byte{}, nil, 0), nil)); err != nil {
+			return nil, err
+		}
+		return bc, nil
+	}
+
+	if !bc.ValidChain(chain) {
+		return nil, fmt.Errorf("stored chain of %d blocks is invalid", len(chain))
+	}
+	bc.indexChain(chain)
+	log.Printf("action=load_chain, blocks=%d", len(chain))
+	return bc, nil
+}
+
+// SetBlockLimits caps the blocks this node mines at maxTransactions
+// transactions and maxBytes encoded transaction bytes, coinbase included.
+// They only shape what this node mines; blocks from peers are not held to
+// them.
+func (bc *Blockchain) SetBlockLimits(maxTransactions int, maxBytes int) {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+
+	bc.maxBlockTransactions = maxTransactions
+	bc.maxBlockBytes = maxBytes
+}
+
+// SetMineEmptyBlocks sets whether this node mines blocks while its pool is
+// empty. Such blocks only hold the coinbase, but the reward is the only way
+// coins enter circulation, so a new network needs them.
+func (bc *Blockchain) SetMineEmptyBlocks(mineEmpty bool) {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+
+	bc.mineEmptyBlocks = mineEmpty
 }
 
 func (bc *Blockchain) Run() {
 	bc.StartSyncNeighbors()
+	if err := bc.StartSync(); err != nil {
+		log.Printf("ERROR: start sync: %v", err)
+	}
 }
 
 func (bc *Blockchain) SetNeighbors() {
 	bc.neighbors = utils.FindNeighbors(
 		utils.GetHost(), bc.port,
 		NIEGHBOR_IP_RANGE_START,
-		NIEGHBOR_IP_RANGE_START,
+		NIEGHBOR_IP_RANGE_END,
 		BLOCKCHAIN_PORT_RANGE_START,
 		BLOCKCHAIN_PORT_RANGE_END,
 	)
@@ -78,8 +179,17 @@ func (bc *Blockchain) StartSyncNeighbors() {
 	_ = time.AfterFunc(time.Second*BLOCKCHAIN_NEIGHBOR_SYNC_TIME, bc.StartSyncNeighbors)
 }
 
+func (bc *Blockchain) Neighbors() []string {
+	bc.muxNeighbors.Lock()
+	defer bc.muxNeighbors.Unlock()
+
+	neighbors := make([]string, len(bc.neighbors))
+	copy(neighbors, bc.neighbors)
+	return neighbors
+}
+
 func (bc *Blockchain) TransactionPool() []*transaction.Transaction {
-	return bc.transactionPool
+	return bc.mempool.Transactions()
 }
 
 func (bc *Blockchain) MarshalJSON() ([]byte, error) {
@@ -87,53 +197,329 @@ func (bc *Blockchain) MarshalJSON() ([]byte, error) {
 		struct {
 			Block []*block.Block `json:"chains"`
 		}{
-			Block: bc.chain,
+			Block: bc.Chain(),
 		})
 }
 
-func (bc *Blockchain) CreateBlock(nonce int, previousHash [32]byte) *block.Block {
-	b := block.NewBlock(nonce, previousHash, bc.transactionPool)
+func (bc *Blockchain) UnmarshalJSON(data []byte) error {
+	v := &struct {
+		Block *[]*block.Block `json:"chains"`
+	}{
+		Block: &bc.chain,
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return err
+	}
+	for _, b := range bc.chain {
+		if b == nil {
+			return errors.New("chain has an empty block")
+		}
+	}
+	return nil
+}
+
+// Chain returns a copy of the main chain, so it can be read while blocks
+// are connected.
+func (bc *Blockchain) Chain() []*block.Block {
+	bc.muxPool.Lock()
+	defer bc.muxPool.Unlock()
+
+	return append([]*block.Block{}, bc.chain...)
+}
+
+// CreateBlock appends b to the chain as is, on top of the tip of the block
+// tree, and drops its transactions from the mempool. Pending transactions it
+// does not include stay there. A block the store fails to write is not
+// appended. The caller must hold mux or own bc exclusively.
+func (bc *Blockchain) CreateBlock(b *block.Block) (*block.Block, error) {
+	bc.muxPool.Lock()
+	defer bc.muxPool.Unlock()
+
+	if err := bc.store.AppendBlock(b); err != nil {
+		return nil, fmt.Errorf("store block %d: %w", len(bc.chain), err)
+	}
+	var parent *treeNode = nil
+	if len(bc.chain) > 0 {
+		parent = bc.tip()
+	}
+	bc.addNode(b, parent)
+	bc.connectBlock(b)
+	bc.mempool.RemoveIncluded(b.Transactions)
+	return b, nil
+}
+
+// connectBlock appends b to the chain and adds its transactions to the
+// transaction IDs, sender nonces, unspent outputs and balances known from
+// the chain. It keeps the undo data of b alongside, in bc.undo. The caller
+// must hold muxPool or own bc exclusively.
+func (bc *Blockchain) connectBlock(b *block.Block) {
 	bc.chain = append(bc.chain, b)
-	bc.transactionPool = []*transaction.Transaction{}
+	for _, t := range b.Transactions {
+		bc.seenTransactions[t.ID()] = true
+		if t.SenderBlockchainAddress != MINNING_SENDER {
+			bc.confirmedNonces[t.SenderBlockchainAddress]++
+		}
+	}
+	bc.undo = append(bc.undo, bc.utxos.Connect(b.Transactions))
+}
+
+// disconnectBlock takes the last block off the chain and reverts what
+// connectBlock did for it. The caller must hold muxPool.
+func (bc *Blockchain) disconnectBlock() *block.Block {
+	last := len(bc.chain) - 1
+	b := bc.chain[last]
+	for _, t := range b.Transactions {
+		delete(bc.seenTransactions, t.ID())
+		if t.SenderBlockchainAddress != MINNING_SENDER {
+			bc.confirmedNonces[t.SenderBlockchainAddress]--
+			if bc.confirmedNonces[t.SenderBlockchainAddress] == 0 {
+				delete(bc.confirmedNonces, t.SenderBlockchainAddress)
+			}
+		}
+	}
+	bc.utxos.Disconnect(bc.undo[last])
+	// Cap the chain so the next append copies it instead of overwriting a
+	// block readers of an earlier Chain() may still hold.
+	bc.chain = bc.chain[:last:last]
+	bc.undo = bc.undo[:last]
 	return b
 }
 
-func (bc *Blockchain) CreateTransaction(sender string, recipient string, value float32,
-	senderPublicKey *ecdsa.PublicKey, s *utils.Signature) bool {
-	isTransacted := bc.AddTransaction(sender, recipient, value, senderPublicKey, s)
+// indexChain connects chain from scratch, as the only branch of a new block
+// tree. The caller must own bc exclusively.
+func (bc *Blockchain) indexChain(chain []*block.Block) {
+	bc.seenTransactions = make(map[[32]byte]bool)
+	bc.confirmedNonces = make(map[string]uint64)
+	bc.utxos = utxo.NewSet()
+	bc.chain = make([]*block.Block, 0, len(chain))
+	bc.undo = make([]*utxo.Undo, 0, len(chain))
+	bc.blocks = make(map[[32]byte]*treeNode)
+	bc.invalidBlocks = make(map[[32]byte]bool)
+	var parent *treeNode = nil
+	for _, b := range chain {
+		parent = bc.addNode(b, parent)
+		bc.connectBlock(b)
+	}
+}
+
+// NextNonce is the nonce the sender's next transaction must carry: one past
+// every transaction of theirs on the chain and in the pool.
+func (bc *Blockchain) NextNonce(sender string) uint64 {
+	bc.muxPool.Lock()
+	defer bc.muxPool.Unlock()
+
+	return bc.nextNonce(sender)
+}
+
+// nextNonce is NextNonce for callers already holding muxPool.
+func (bc *Blockchain) nextNonce(sender string) uint64 {
+	return bc.confirmedNonces[sender] + uint64(len(bc.mempool.BySender(sender)))
+}
+
+func (bc *Blockchain) CreateTransaction(t *transaction.Transaction) error {
+	err := bc.AddTransaction(t)
+	if err != nil {
+		return err
+	}
+
+	go bc.BroadcastTransaction(t)
+	return nil
+}
+
+// BroadcastTransaction forwards an accepted transaction to every neighbor
+// through PUT /transaction. Neighbors relay it in turn, and the seen
+// transaction IDs in AddTransaction stop it from circulating forever.
+func (bc *Blockchain) BroadcastTransaction(t *transaction.Transaction) {
+	m, _ := json.Marshal(&TransactionRequest{
+		SenderBlockchainAddress:    &t.SenderBlockchainAddress,
+		RecipientBlockchainAddress: &t.RecipientBlockchainAddress,
+		SenderPublicKey:            &t.SenderPublicKey,
+		Value:                      &t.Value,
+		Fee:                        &t.Fee,
+		Change:                     &t.Change,
+		Inputs:                     &t.Inputs,
+		Nonce:                      &t.Nonce,
+		Timestamp:                  &t.Timestamp,
+		Signature:                  &t.Signature,
+	})
+
+	client := &http.Client{Timeout: NEIGHBOR_REQUEST_TIMEOUT}
+	for _, n := range bc.Neighbors() {
+		endpoint := fmt.Sprintf("http://%s/transaction", n)
+		req, _ := http.NewRequest(http.MethodPut, endpoint, bytes.NewBuffer(m))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			log.Printf("ERROR: broadcast transaction to %s: %v", n, err)
+			continue
+		}
+		resp.Body.Close()
+	}
+}
+
+// AddTransaction verifies a signed transaction and puts it in the mempool.
+// Coinbase transactions are never accepted here, only created by Mining.
+func (bc *Blockchain) AddTransaction(t *transaction.Transaction) error {
+	sender := t.SenderBlockchainAddress
 
-	// TODO
-	// Sync
+	bc.muxPool.Lock()
+	defer bc.muxPool.Unlock()
 
-	return isTransacted
+	if err := bc.VerifyTransaction(t); err != nil {
+		return err
+	}
+
+	bc.expireTransactions()
+
+	if id := t.ID(); bc.seenTransactions[id] || bc.mempool.Has(id) {
+		return ErrTransactionSeen
+	}
+
+	if expected := bc.nextNonce(sender); t.Nonce != expected {
+		return fmt.Errorf("%w: got %d, expected %d", ErrInvalidNonce, t.Nonce, expected)
+	}
+
+	if err := utxo.Check(bc.pendingView(sender), t); err != nil {
+		return err
+	}
+
+	evicted, err := bc.mempool.Add(t)
+	if err != nil {
+		return err
+	}
+	for _, e := range evicted {
+		log.Printf("action=mempool_evict, transaction=%s", e.IDString())
+	}
+	return nil
+}
+
+// expireTransactions drops transactions that waited in the mempool longer
+// than MEMPOOL_TTL.
+func (bc *Blockchain) expireTransactions() {
+	for _, e := range bc.mempool.Expire(time.Now()) {
+		log.Printf("action=mempool_expire, transaction=%s", e.IDString())
+	}
 }
 
-func (bc *Blockchain) AddTransaction(sender string, recipient string, value float32, senderPublicKey *ecdsa.PublicKey, s *utils.Signature) bool {
-	t := transaction.NewTransaction(sender, recipient, value)
+// coinbase is the reward transaction closing a block of transactions: the
+// mining reward plus their fees. Its nonce is the height of that block,
+// which keeps every coinbase ID unique.
+func (bc *Blockchain) coinbase(transactions []*transaction.Transaction) *transaction.Transaction {
+	reward, err := blockReward(transactions)
+	if err != nil {
+		log.Printf("ERROR: block reward: %v", err)
+		reward = MINNING_REWARD
+	}
+
+	return transaction.NewTransaction(MINNING_SENDER, bc.blockchainAddress, reward, 0,
+		uint64(len(bc.chain)), time.Now().UnixNano())
+}
+
+// blockReward is MINNING_REWARD plus the fees of transactions.
+func blockReward(transactions []*transaction.Transaction) (amount.Amount, error) {
+	reward := MINNING_REWARD
+	for _, t := range transactions {
+		if t.SenderBlockchainAddress == MINNING_SENDER {
+			continue
+		}
+		var err error
+		if reward, err = reward.Add(t.Fee); err != nil {
+			return 0, err
+		}
+	}
+	return reward, nil
+}
+
+// pendingView is the unspent outputs with the sender's pending transactions
+// applied. Inputs may only be the sender's own outputs, so no other pending
+// transaction can touch them. The caller must hold muxPool.
+func (bc *Blockchain) pendingView(sender string) *utxo.View {
+	view := utxo.NewView(bc.utxos)
+	for _, t := range bc.mempool.BySender(sender) {
+		view.Apply(t)
+	}
+	return view
+}
+
+// SpendableOutputs are the outputs of address its next transaction can
+// spend: unspent on the chain and not spent by its pending transactions,
+// plus the change of those pending transactions.
+func (bc *Blockchain) SpendableOutputs(address string) []utxo.Entry {
+	bc.muxPool.Lock()
+	defer bc.muxPool.Unlock()
+
+	return bc.pendingView(address).ByAddress(address)
+}
+
+// revalidatePool drops pending transactions the current chain no longer
+// allows: nonces already used, or inputs that are gone, together with every
+// later transaction of the same sender. The caller must hold muxPool.
+func (bc *Blockchain) revalidatePool() {
+	senders := make(map[string]bool)
+	for _, t := range bc.mempool.Transactions() {
+		senders[t.SenderBlockchainAddress] = true
+	}
+
+	for sender := range senders {
+		nonce := bc.confirmedNonces[sender]
+		view := utxo.NewView(bc.utxos)
+		var stale [][32]byte
+		for _, t := range bc.mempool.BySender(sender) {
+			if len(stale) == 0 && t.Nonce == nonce && utxo.Check(view, t) == nil {
+				nonce++
+				view.Apply(t)
+				continue
+			}
+			stale = append(stale, t.ID())
+		}
+
+		bc.mempool.Remove(stale...)
+		for _, id := range stale {
+			log.Printf("action=mempool_drop, transaction=%x", id)
+		}
+	}
+}
+
+// VerifyTransaction checks everything about a non-coinbase transaction that
+// does not depend on chain state: well formed addresses, key and signature,
+// a sender address derived from the key, and a valid signature. Malformed
+// keys and signatures are rejected before any curve arithmetic.
+func (bc *Blockchain) VerifyTransaction(t *transaction.Transaction) error {
+	if t.SenderBlockchainAddress == MINNING_SENDER {
+		return ErrInvalidSignature
+	}
+
+	if err := wallet.ValidateAddress(t.SenderBlockchainAddress); err != nil {
+		return err
+	}
+	if err := wallet.ValidateAddress(t.RecipientBlockchainAddress); err != nil {
+		return err
+	}
 
-	if sender == MINNING_SENDER {
-		bc.transactionPool = append(bc.transactionPool, t)
-		return true
+	senderPublicKey, err := utils.PublicKeyFromString(t.SenderPublicKey)
+	if err != nil {
+		return err
+	}
+	s, err := utils.SignatureFromString(t.Signature)
+	if err != nil {
+		return err
 	}
 
-	if bc.VerifyTransactionSignature(senderPublicKey, s, t) {
-		// if bc.CalculateTotalAmount(sender) < value {
-		// 	fmt.Println(bc.CalculateTotalAmount((sender)))
-		// 	log.Panicln("ERROR: Wallet balance low")
+	if wallet.AddressFromPublicKey(senderPublicKey) != t.SenderBlockchainAddress {
+		return ErrSenderMismatch
+	}
 
-		// }
-		bc.transactionPool = append(bc.transactionPool, t)
-		return true
-	} else {
-		log.Panicln("ERROR: Verify Transaction")
+	if !bc.VerifyTransactionSignature(senderPublicKey, s, t) {
+		return ErrInvalidSignature
 	}
-	return false
+	return nil
 }
 
 func (bc *Blockchain) VerifyTransactionSignature(
 	senderPublicKey *ecdsa.PublicKey, s *utils.Signature, t *transaction.Transaction,
 ) bool {
-	m, _ := t.MarshalJSON()
+	m, _ := t.SigningPayload()
 	h := sha256.Sum256(m)
 	return ecdsa.Verify(senderPublicKey, h[:], s.R, s.S)
 }
@@ -144,72 +530,385 @@ func (bc *Blockchain) Print() {
 	// 	txPool.Print()
 	// 	fmt.Printf("%s\n", strings.Repeat("*", 55))
 	// }
-	for i, block := range bc.chain {
+	for i, block := range bc.Chain() {
 		fmt.Printf("%s Chain %d %s \n", strings.Repeat("=", 25), i, strings.Repeat("=", 25))
 		block.Print()
 		fmt.Printf("%s\n", strings.Repeat("*", 55))
 	}
 }
 
-func (bc *Blockchain) CopyTransactionPool() []*transaction.Transaction {
-	transactions := make([]*transaction.Transaction, 0)
-	for _, t := range bc.transactionPool {
-		transactions = append(transactions, transaction.NewTransaction(t.SenderBlockchainAddress, t.RecipientBlockchainAddress, t.Value))
-	}
-	return transactions
+// ValidProof reports whether the header hash starts with at least as many
+// zero bits as the header's difficulty asks for.
+func (bc *Blockchain) ValidProof(h *block.Header) bool {
+	return leadingZeroBits(h.Hash()) >= h.Difficulty
 }
 
-func (bc *Blockchain) ValidProof(nonce int, previousHash [32]byte, transaction []*transaction.Transaction, difficulty int) bool {
-	zeros := strings.Repeat("0", difficulty)
-	guessBlock := block.NewBlock(nonce, previousHash, transaction)
-	guessHashStr := fmt.Sprintf("%x", guessBlock.CalculateHash())
-	return guessHashStr[:difficulty] == zeros
+// ProofOfWork searches the nonce of h until its hash satisfies the
+// difficulty. Every other field, the timestamp included, stays as it is.
+func (bc *Blockchain) ProofOfWork(h *block.Header) {
+	h.Nonce = 0
+	for !bc.ValidProof(h) {
+		h.Nonce += 1
+	}
 }
 
-func (bc *Blockchain) ProofOfWork() int {
-	transactions := bc.CopyTransactionPool()
-	previousHash := bc.LastBlock().CalculateHash()
-	nonce := 0
-	for !bc.ValidProof(nonce, previousHash, transactions, MINNING_DIFFICULTY) {
-		nonce += 1
+// Mining mines one block on top of the main chain and announces it. The
+// nonce search runs without mux, so blocks keep being served and received
+// meanwhile; a block whose parent stopped being the tip by then is dropped.
+// It reports false when nothing was mined: the pool is empty and empty
+// blocks are turned off, the tip moved, or the block could not be stored.
+func (bc *Blockchain) Mining() bool {
+	header, transactions := bc.blockTemplate()
+	if header == nil {
+		log.Println("action=mining, status=empty_pool")
+		return false
 	}
-	return nonce
+
+	bc.ProofOfWork(header)
+	b := block.NewBlock(header, transactions)
+	if !bc.appendMined(b) {
+		return false
+	}
+	log.Println("action=mining, status=success")
+
+	go bc.BroadcastBlock(b)
+	return true
 }
 
-func (bc *Blockchain) Mining() bool {
+// appendMined appends the freshly mined b to the chain, unless its parent
+// stopped being the tip while the nonce was searched.
+func (bc *Blockchain) appendMined(b *block.Block) bool {
 	bc.mux.Lock()
 	defer bc.mux.Unlock()
 
-	if len(bc.transactionPool) == 0 {
+	if bc.LastBlock().CalculateHash() != b.Header.PrevHash {
+		log.Println("action=mining, status=stale_tip")
+		return false
+	}
+	if _, err := bc.CreateBlock(b); err != nil {
+		log.Printf("ERROR: mining: %v", err)
 		return false
 	}
-
-	bc.AddTransaction(MINNING_SENDER, bc.blockchainAddress, MINNING_REWARD, nil, nil)
-	nonce := bc.ProofOfWork()
-	previousHash := bc.LastBlock().CalculateHash()
-	bc.CreateBlock(nonce, previousHash)
-	log.Println("action=mining, status=success")
 	return true
 }
 
+// blockTemplate is the header and transactions of the next block on the
+// main chain, with the nonce still to be found. The header is nil when the
+// pool is empty and empty blocks are turned off.
+func (bc *Blockchain) blockTemplate() (*block.Header, []*transaction.Transaction) {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+
+	bc.muxPool.Lock()
+	bc.expireTransactions()
+	empty := bc.mempool.Len() == 0
+	bc.muxPool.Unlock()
+	if empty && !bc.mineEmptyBlocks {
+		return nil, nil
+	}
+
+	transactions := bc.selectTransactions()
+	transactions = append(transactions, bc.coinbase(transactions))
+	header := block.NewHeader(uint64(len(bc.chain)), bc.LastBlock().CalculateHash(), transactions,
+		bc.NextDifficulty(bc.chain))
+	if last := bc.LastBlock().Header; len(bc.chain) > 1 && header.Timestamp <= last.Timestamp {
+		header.Timestamp = last.Timestamp + 1
+	}
+	return header, transactions
+}
+
+// selectTransactions picks the pending transactions for the next block by
+// fee rate within the block limits, leaving room for the coinbase. Once a
+// sender's transaction does not fit, that sender's later ones are skipped as
+// well so nonces stay in order; the rest wait for a later block. The caller
+// must hold mux.
+func (bc *Blockchain) selectTransactions() []*transaction.Transaction {
+	reserve := transaction.NewTransaction(MINNING_SENDER, bc.blockchainAddress, amount.MAX, 0,
+		uint64(len(bc.chain)), time.Now().UnixNano())
+	count, bytes := 1, reserve.Size()
+
+	skipped := make(map[string]bool)
+	selected := make([]*transaction.Transaction, 0)
+	for _, t := range bc.mempool.ByFeeRate() {
+		sender := t.SenderBlockchainAddress
+		if skipped[sender] {
+			continue
+		}
+		if count+1 > bc.maxBlockTransactions || bytes+t.Size() > bc.maxBlockBytes {
+			skipped[sender] = true
+			continue
+		}
+		selected = append(selected, t)
+		count++
+		bytes += t.Size()
+	}
+	return selected
+}
+
 func (bc *Blockchain) StartMining() {
 	bc.Mining()
 	_ = time.AfterFunc(time.Second*MINING_TIMER_SEC, bc.StartMining)
 }
 
-func (bc *Blockchain) CalculateTotalAmount(senderAddress string) float32 {
-	var total float32 = 0
-	for _, chain := range bc.chain {
-		for _, transaction := range chain.Transactions {
-			if senderAddress == transaction.RecipientBlockchainAddress {
-				total += transaction.Value
+// ValidChain checks that the chain starts with a genesis block and that
+// every later block passes checkHeader on top of its parent and checkBlock
+// on top of the blocks before it.
+// The genesis block is local to each node; only its shape is checked.
+func (bc *Blockchain) ValidChain(chain []*block.Block) bool {
+	if len(chain) == 0 || !validGenesis(chain[0]) {
+		return false
+	}
+
+	seen := make(map[[32]byte]bool)
+	nonces := make(map[string]uint64)
+	utxos := utxo.NewSet()
+
+	for height := 1; height < len(chain); height++ {
+		b := chain[height]
+		err := bc.checkHeader(b.Header, chain[height-1].Header, bc.NextDifficulty(chain[:height]))
+		if err == nil {
+			err = bc.checkBlock(b, seen, nonces, utxos)
+		}
+		if err != nil {
+			log.Printf("ERROR: block %d: %v", height, err)
+			return false
+		}
+
+		for _, t := range b.Transactions {
+			seen[t.ID()] = true
+			if t.SenderBlockchainAddress != MINNING_SENDER {
+				nonces[t.SenderBlockchainAddress]++
+			}
+		}
+		utxos.Connect(b.Transactions)
+	}
+	return true
+}
+
+// validGenesis reports whether b has the shape of a genesis block: height
+// 0, no parent, difficulty 0 and no transactions, so it cannot create coins
+// or claim work it did not do.
+func validGenesis(b *block.Block) bool {
+	return validGenesisHeader(b.Header) && len(b.Transactions) == 0
+}
+
+// validGenesisHeader is validGenesis for a header alone: its merkle root
+// must be the one of no transactions.
+func validGenesisHeader(h *block.Header) bool {
+	return h.Version == block.BLOCK_VERSION && h.Height == 0 && h.PrevHash == [32]byte{} &&
+		h.Difficulty == 0 && h.MerkleRoot == block.MerkleRoot(nil)
+}
+
+// checkHeader checks h as the child of parent: it links to the parent hash,
+// carries the next height, a timestamp after its parent's and not too far
+// ahead, and the difficulty the chain asks for, and satisfies the proof of
+// work.
+func (bc *Blockchain) checkHeader(h *block.Header, parent *block.Header, difficulty int) error {
+	if h.Version != block.BLOCK_VERSION || h.Height != parent.Height+1 || h.PrevHash != parent.Hash() {
+		return fmt.Errorf("%w: does not extend its parent", ErrInvalidBlock)
+	}
+
+	if h.Timestamp <= 0 || h.Timestamp > time.Now().Add(BLOCK_MAX_FUTURE_TIME).UnixNano() ||
+		(parent.Height > 0 && h.Timestamp <= parent.Timestamp) {
+		return fmt.Errorf("%w: timestamp out of range", ErrInvalidBlock)
+	}
+
+	if h.Difficulty != difficulty {
+		return fmt.Errorf("%w: difficulty %d, want %d", ErrInvalidBlock, h.Difficulty, difficulty)
+	}
+	if !bc.ValidProof(h) {
+		return fmt.Errorf("%w: proof of work does not meet difficulty", ErrInvalidBlock)
+	}
+	return nil
+}
+
+// checkBlock checks the transactions of b against the transaction IDs,
+// sender nonces and unspent outputs of the chain it extends, without
+// changing them: the merkle root, signatures, nonces counting up per
+// sender, no duplicates, inputs that are unspent outputs of the sender and
+// add up to the outputs plus fee, and one coinbase paying the reward plus
+// the block's fees.
+func (bc *Blockchain) checkBlock(b *block.Block, seen map[[32]byte]bool, nonces map[string]uint64, utxos *utxo.Set) error {
+	height := b.Header.Height
+	if b.Header.MerkleRoot != block.MerkleRoot(b.Transactions) {
+		return fmt.Errorf("%w: merkle root does not match transactions", ErrInvalidBlock)
+	}
+
+	reward, err := blockReward(b.Transactions)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidBlock, err)
+	}
+
+	blockSeen := make(map[[32]byte]bool)
+	blockNonces := make(map[string]uint64)
+	view := utxo.NewView(utxos)
+	coinbases := 0
+	for _, t := range b.Transactions {
+		id := t.ID()
+		if seen[id] || blockSeen[id] {
+			return fmt.Errorf("%w: transaction %s: %v", ErrInvalidBlock, t.IDString(), ErrTransactionSeen)
+		}
+		blockSeen[id] = true
+
+		if t.SenderBlockchainAddress == MINNING_SENDER {
+			coinbases++
+			if coinbases > 1 || t.Nonce != height || t.Value != reward || t.Fee != 0 ||
+				t.Change != 0 || len(t.Inputs) != 0 || t.SenderPublicKey != "" || t.Signature != "" {
+				return fmt.Errorf("%w: invalid coinbase", ErrInvalidBlock)
+			}
+			view.Apply(t)
+			continue
+		}
+
+		if err := bc.VerifyTransaction(t); err != nil {
+			return fmt.Errorf("%w: transaction %s: %v", ErrInvalidBlock, t.IDString(), err)
+		}
+
+		sender := t.SenderBlockchainAddress
+		nonce, ok := blockNonces[sender]
+		if !ok {
+			nonce = nonces[sender]
+		}
+		if t.Nonce != nonce {
+			return fmt.Errorf("%w: transaction %s: %v", ErrInvalidBlock, t.IDString(), ErrInvalidNonce)
+		}
+		blockNonces[sender] = nonce + 1
+
+		if err := utxo.Check(view, t); err != nil {
+			return fmt.Errorf("%w: transaction %s: %v", ErrInvalidBlock, t.IDString(), err)
+		}
+		view.Apply(t)
+	}
+	return nil
+}
+
+// ResolveConflicts fetches the chain of every neighbor and adds its blocks
+// to the block tree, then moves the main chain to the branch with the most
+// cumulative work. It reports whether the main chain changed.
+func (bc *Blockchain) ResolveConflicts() bool {
+	chains := make(map[string][]*block.Block)
+
+	client := &http.Client{Timeout: NEIGHBOR_REQUEST_TIMEOUT}
+	for _, n := range bc.Neighbors() {
+		endpoint := fmt.Sprintf("http://%s/", n)
+		resp, err := client.Get(endpoint)
+		if err != nil {
+			log.Printf("ERROR: fetch chain from %s: %v", n, err)
+			continue
+		}
+
+		var bcResp Blockchain
+		err = json.NewDecoder(resp.Body).Decode(&bcResp)
+		resp.Body.Close()
+		if err != nil {
+			log.Printf("ERROR: decode chain from %s: %v", n, err)
+			continue
+		}
+		chains[n] = bcResp.Chain()
+	}
+
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+
+	for n, chain := range chains {
+		for _, b := range chain {
+			if _, err := bc.addBlock(b); err != nil {
+				log.Printf("ERROR: block %d from %s: %v", b.Header.Height, n, err)
+				break
 			}
-			if senderAddress == transaction.SenderBlockchainAddress {
-				total -= transaction.Value
+		}
+	}
+
+	bc.muxPool.Lock()
+	defer bc.muxPool.Unlock()
+
+	if !bc.activateBestChain() {
+		log.Println("action=resolve_conflicts, status=not_replaced")
+		return false
+	}
+	log.Println("action=resolve_conflicts, status=replaced")
+	return true
+}
+
+// CalculateTotalAmount is the sum of the unspent outputs of senderAddress
+// on the chain.
+func (bc *Blockchain) CalculateTotalAmount(senderAddress string) amount.Amount {
+	bc.muxPool.Lock()
+	defer bc.muxPool.Unlock()
+
+	return bc.utxos.Balance(senderAddress)
+}
+
+// Balances returns the confirmed balance of address, from the chain alone,
+// and its pending balance, as it will be once every pooled transaction is
+// mined. Both come from the same state of the chain and pool.
+func (bc *Blockchain) Balances(address string) (amount.Amount, amount.Amount) {
+	bc.muxPool.Lock()
+	defer bc.muxPool.Unlock()
+
+	confirmed := bc.utxos.Balance(address)
+	pending, err := bc.pendingBalance(address, confirmed)
+	if err != nil {
+		log.Printf("ERROR: pending balance of %s: %v", address, err)
+		return confirmed, confirmed
+	}
+	return confirmed, pending
+}
+
+// pendingBalance adds to confirmed what the pool pays address and takes off
+// what address spends in it. A pooled transaction always spends exactly its
+// Cost from its sender's outputs, so the inputs need no lookup. The caller
+// must hold muxPool.
+func (bc *Blockchain) pendingBalance(address string, confirmed amount.Amount) (amount.Amount, error) {
+	credit, debit := confirmed, amount.Amount(0)
+	for _, t := range bc.mempool.Transactions() {
+		var err error
+		if t.RecipientBlockchainAddress == address {
+			if credit, err = credit.Add(t.Value); err != nil {
+				return 0, err
+			}
+		}
+		if t.SenderBlockchainAddress != address {
+			continue
+		}
+		if credit, err = credit.Add(t.Change); err != nil {
+			return 0, err
+		}
+		cost, err := t.Cost()
+		if err != nil {
+			return 0, err
+		}
+		if debit, err = debit.Add(cost); err != nil {
+			return 0, err
+		}
+	}
+	return credit.Sub(debit)
+}
+
+// TransactionProof finds the block holding the transaction with id and
+// proves its inclusion against the merkle root in the block header.
+func (bc *Blockchain) TransactionProof(id [32]byte) (*ProofResponse, error) {
+	chain := bc.Chain()
+	for i := len(chain) - 1; i >= 0; i-- {
+		for index, t := range chain[i].Transactions {
+			if t.ID() != id {
+				continue
+			}
+			proof, err := merkle.NewProof(block.TransactionHashes(chain[i].Transactions), index)
+			if err != nil {
+				return nil, err
 			}
+			return &ProofResponse{
+				BlockHash:       fmt.Sprintf("%x", chain[i].CalculateHash()),
+				Header:          chain[i].Header,
+				TransactionID:   fmt.Sprintf("%x", id),
+				TransactionHash: fmt.Sprintf("%x", t.Hash()),
+				Transaction:     t,
+				Proof:           proof,
+			}, nil
 		}
 	}
-	return total
+	return nil, ErrUnknownTransaction
 }
 
 func (bc *Blockchain) LastBlock() *block.Block {
@@ -217,30 +916,68 @@ func (bc *Blockchain) LastBlock() *block.Block {
 }
 
 type TransactionRequest struct {
-	SenderBlockchainAddress    *string  `json:"sender_blockchain_address"`
-	RecipientBlockchainAddress *string  `json:"recipient_blockchain_address"`
-	SenderPublicKey            *string  `json:"sender_public_key"`
-	Value                      *float32 `json:"value"`
-	Signature                  *string  `json:"signature"`
+	SenderBlockchainAddress    *string                 `json:"sender_blockchain_address"`
+	RecipientBlockchainAddress *string                 `json:"recipient_blockchain_address"`
+	SenderPublicKey            *string                 `json:"sender_public_key"`
+	Value                      *amount.Amount          `json:"value"`
+	Fee                        *amount.Amount          `json:"fee,omitempty"`
+	Change                     *amount.Amount          `json:"change,omitempty"`
+	Inputs                     *[]transaction.OutPoint `json:"inputs,omitempty"`
+	Nonce                      *uint64                 `json:"nonce"`
+	Timestamp                  *int64                  `json:"timestamp"`
+	Signature                  *string                 `json:"signature"`
 }
 
 func (tr *TransactionRequest) Validate() bool {
 	if tr.SenderBlockchainAddress == nil || tr.RecipientBlockchainAddress == nil ||
-		tr.SenderPublicKey == nil || tr.Value == nil || tr.Signature == nil {
+		tr.SenderPublicKey == nil || tr.Value == nil || tr.Nonce == nil ||
+		tr.Timestamp == nil || tr.Signature == nil {
 		return false
 	}
 
 	return true
 }
 
+// AmountResponse carries the confirmed balance in Amount, as it always
+// has, and again in Confirmed next to Pending.
 type AmountResponse struct {
-	Amount float32 `json:"amount"`
+	Amount    amount.Amount `json:"amount"`
+	Confirmed amount.Amount `json:"confirmed"`
+	Pending   amount.Amount `json:"pending"`
 }
 
 func (ar *AmountResponse) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
-		Amount float32 `json:"amount"`
+		Amount    amount.Amount `json:"amount"`
+		Confirmed amount.Amount `json:"confirmed"`
+		Pending   amount.Amount `json:"pending"`
 	}{
-		Amount: ar.Amount,
+		Amount:    ar.Amount,
+		Confirmed: ar.Confirmed,
+		Pending:   ar.Pending,
 	})
 }
+
+// ProofResponse lets a light client check a payment: Transaction has the
+// ID TransactionID and the hash TransactionHash, that hash and Proof lead to
+// Header.MerkleRoot, and the header hash is BlockHash.
+type ProofResponse struct {
+	BlockHash       string                   `json:"blockHash"`
+	Header          *block.Header            `json:"header"`
+	TransactionID   string                   `json:"transactionId"`
+	TransactionHash string                   `json:"transactionHash"`
+	Transaction     *transaction.Transaction `json:"transaction"`
+	Proof           *merkle.Proof            `json:"proof"`
+}
+
+type UTXOResponse struct {
+	Outputs []utxo.Entry `json:"outputs"`
+}
+
+type ReorgsResponse struct {
+	Reorgs []*ReorgEvent `json:"reorgs"`
+}
+
+type NonceResponse struct {
+	Nonce uint64 `json:"nonce"`
+}
@@ -0,0 +1,63 @@
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/Ethical-Ralph/go-block/amount"
+	"github.com/Ethical-Ralph/go-block/block"
+	"github.com/Ethical-Ralph/go-block/store"
+	"github.com/Ethical-Ralph/go-block/wallet"
+)
+
+func TestMining(t *testing.T) {
+	s := store.NewMemoryStore()
+	bc := newTestBlockchain(t, s)
+	miner := bc.blockchainAddress
+	bc.SetMineEmptyBlocks(false)
+	if bc.Mining() {
+		t.Fatal("mined an empty block with empty blocks turned off")
+	}
+
+	alice := wallet.NewWallet()
+	receive(t, bc, mine(bc, bc.Chain()[0], alice.BlockchainAddress()))
+	payment := pay(alice, wallet.NewWallet().BlockchainAddress(), amount.COIN/2, amount.COIN/10, 0,
+		bc.SpendableOutputs(alice.BlockchainAddress())...)
+	if err := bc.AddTransaction(payment); err != nil {
+		t.Fatal(err)
+	}
+	if !bc.Mining() {
+		t.Fatal("Mining failed")
+	}
+
+	chain := bc.Chain()
+	if len(chain) != 3 || len(chain[2].Transactions) != 2 || chain[2].Transactions[0].ID() != payment.ID() {
+		t.Fatal("payment not mined in block 2")
+	}
+	if got := bc.CalculateTotalAmount(miner); got != MINNING_REWARD+amount.COIN/10 {
+		t.Errorf("miner has %s, want the reward and the fee", got)
+	}
+	if n := len(bc.TransactionPool()); n != 0 {
+		t.Errorf("pool holds %d transactions after mining", n)
+	}
+	checkStored(t, bc, s)
+}
+
+func TestMiningStaleTip(t *testing.T) {
+	bc := newTestBlockchain(t, store.NewMemoryStore())
+	header, transactions := bc.blockTemplate()
+
+	// A block arriving during the nonce search replaces the parent.
+	b1 := mine(bc, bc.Chain()[0], wallet.NewWallet().BlockchainAddress())
+	receive(t, bc, b1)
+
+	bc.ProofOfWork(header)
+	if bc.appendMined(block.NewBlock(header, transactions)) {
+		t.Fatal("mined block appended on a stale tip")
+	}
+	if tipHash(bc) != b1.CalculateHash() || len(bc.Chain()) != 2 {
+		t.Error("stale block changed the chain")
+	}
+	if !bc.Mining() || len(bc.Chain()) != 3 {
+		t.Error("Mining does not build on the new tip")
+	}
+}
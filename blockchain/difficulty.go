@@ -0,0 +1,93 @@
+package blockchain
+
+import (
+	"math/bits"
+	"time"
+
+	"github.com/Ethical-Ralph/go-block/block"
+)
+
+const (
+	// Difficulty is the number of leading zero bits a block hash needs.
+	// Blocks start at MINNING_DIFFICULTY and are retargeted every
+	// DIFFICULTY_RETARGET_BLOCKS blocks by at most DIFFICULTY_MAX_STEP bits,
+	// that is a factor of 4, staying within the MIN and MAX bounds.
+	DIFFICULTY_RETARGET_BLOCKS = 10
+	DIFFICULTY_MAX_STEP        = 2
+	DIFFICULTY_MIN             = 1
+	DIFFICULTY_MAX             = 64
+
+	// BLOCK_TARGET_INTERVAL is the default interval difficulty is retargeted
+	// towards. Nodes with different intervals reject each other's blocks
+	// once the first retarget happens.
+	BLOCK_TARGET_INTERVAL = 30 * time.Second
+
+	// Blocks may not claim a time further than this ahead of the validating
+	// node's clock.
+	BLOCK_MAX_FUTURE_TIME = 2 * time.Hour
+)
+
+// NextDifficulty is the difficulty the block at len(chain) must have on top
+// of chain. Block 1 starts at MINNING_DIFFICULTY and later blocks keep their
+// parent's difficulty, except at every DIFFICULTY_RETARGET_BLOCKS-th block
+// after block 1. There the last DIFFICULTY_RETARGET_BLOCKS blocks are
+// compared against the target interval: each halving of the time they took
+// adds a bit, each doubling removes one. The genesis block is local to each
+// node and never part of the measured window.
+func (bc *Blockchain) NextDifficulty(chain []*block.Block) int {
+	if len(chain) == 0 {
+		return MINNING_DIFFICULTY
+	}
+	return bc.nextDifficulty(chain[len(chain)-1].Header, func(back int) *block.Header {
+		return chain[len(chain)-1-back].Header
+	})
+}
+
+// nextDifficulty is NextDifficulty for the child of last, where ancestor
+// returns the header back blocks before last.
+func (bc *Blockchain) nextDifficulty(last *block.Header, ancestor func(back int) *block.Header) int {
+	height := int(last.Height) + 1
+	if height <= 1 {
+		return MINNING_DIFFICULTY
+	}
+
+	if height <= DIFFICULTY_RETARGET_BLOCKS || (height-1)%DIFFICULTY_RETARGET_BLOCKS != 0 {
+		return last.Difficulty
+	}
+
+	first := ancestor(DIFFICULTY_RETARGET_BLOCKS - 1)
+	actual := last.Timestamp - first.Timestamp
+	if actual < 1 {
+		actual = 1
+	}
+	expected := int64(DIFFICULTY_RETARGET_BLOCKS-1) * int64(bc.targetInterval)
+
+	step := 0
+	for step < DIFFICULTY_MAX_STEP && actual <= expected>>(step+1) {
+		step++
+	}
+	for step <= 0 && step > -DIFFICULTY_MAX_STEP && actual >= expected<<(-step+1) {
+		step--
+	}
+
+	difficulty := last.Difficulty + step
+	if difficulty < DIFFICULTY_MIN {
+		difficulty = DIFFICULTY_MIN
+	}
+	if difficulty > DIFFICULTY_MAX {
+		difficulty = DIFFICULTY_MAX
+	}
+	return difficulty
+}
+
+// leadingZeroBits counts the zero bits at the start of hash.
+func leadingZeroBits(hash [32]byte) int {
+	n := 0
+	for _, b := range hash {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+	return n
+}
@@ -0,0 +1,158 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/Ethical-Ralph/go-block/block"
+)
+
+// BLOCK_FETCH_MAX_DEPTH bounds how many missing ancestors of an announced
+// block are fetched one at a time. A node further behind than that pulls
+// whole chains through ResolveConflicts instead.
+const BLOCK_FETCH_MAX_DEPTH = 100
+
+var (
+	ErrKnownBlock = errors.New("block already known")
+
+	errTooFarBehind = errors.New("too many missing ancestors")
+)
+
+// BroadcastBlock announces b to every neighbor through POST /block.
+func (bc *Blockchain) BroadcastBlock(b *block.Block) {
+	m, _ := json.Marshal(b)
+
+	client := &http.Client{Timeout: NEIGHBOR_REQUEST_TIMEOUT}
+	for _, n := range bc.Neighbors() {
+		endpoint := fmt.Sprintf("http://%s/block", n)
+		resp, err := client.Post(endpoint, "application/json", bytes.NewBuffer(m))
+		if err != nil {
+			log.Printf("ERROR: broadcast block to %s: %v", n, err)
+			continue
+		}
+		resp.Body.Close()
+	}
+}
+
+// ReceiveBlock takes a block announced by a neighbor. Missing ancestors are
+// fetched from the neighbors first, then the block goes into the block tree
+// and the main chain moves onto it if its branch has the most work, which
+// also drops its transactions from the pool. A block that changed the main
+// chain is relayed to the neighbors. It reports whether the main chain
+// changed.
+func (bc *Blockchain) ReceiveBlock(b *block.Block) (bool, error) {
+	hash := b.CalculateHash()
+	if bc.hasBlock(hash) {
+		return false, ErrKnownBlock
+	}
+	if b.Header.Height == 0 {
+		// A genesis block costs nothing to make; it only comes in along
+		// with the blocks built on it.
+		return false, fmt.Errorf("%w: genesis block announced on its own", ErrInvalidBlock)
+	}
+
+	missing, err := bc.missingAncestors(b)
+	if errors.Is(err, errTooFarBehind) {
+		log.Printf("action=receive_block, block=%x, status=resolve_conflicts", hash)
+		return bc.ResolveConflicts(), nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+
+	if _, ok := bc.blocks[hash]; ok {
+		return false, ErrKnownBlock
+	}
+	for _, a := range append(missing, b) {
+		if _, err := bc.addBlock(a); err != nil {
+			return false, err
+		}
+	}
+
+	bc.muxPool.Lock()
+	defer bc.muxPool.Unlock()
+
+	changed := bc.activateBestChain()
+	if bc.invalidBlocks[hash] {
+		return changed, fmt.Errorf("%w: %x does not connect", ErrInvalidBlock, hash)
+	}
+	log.Printf("action=receive_block, block=%x, height=%d, fetched=%d, tip_changed=%t",
+		hash, b.Header.Height, len(missing), changed)
+	if changed {
+		go bc.BroadcastBlock(b)
+	}
+	return changed, nil
+}
+
+// BlockByHash finds a block in the block tree, side branches included.
+func (bc *Blockchain) BlockByHash(hash [32]byte) (*block.Block, bool) {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+
+	n, ok := bc.blocks[hash]
+	if !ok {
+		return nil, false
+	}
+	return n.block, true
+}
+
+func (bc *Blockchain) hasBlock(hash [32]byte) bool {
+	_, ok := bc.BlockByHash(hash)
+	return ok
+}
+
+// missingAncestors fetches the ancestors of b the block tree does not have,
+// walking back from its parent, and returns them oldest first.
+func (bc *Blockchain) missingAncestors(b *block.Block) ([]*block.Block, error) {
+	client := &http.Client{Timeout: NEIGHBOR_REQUEST_TIMEOUT}
+	missing := make([]*block.Block, 0)
+	for h := b.Header; h.Height > 0 && !bc.hasBlock(h.PrevHash); {
+		if len(missing) == BLOCK_FETCH_MAX_DEPTH {
+			return nil, errTooFarBehind
+		}
+		parent, err := bc.fetchBlock(client, h.PrevHash)
+		if err != nil {
+			return nil, err
+		}
+		missing = append(missing, parent)
+		h = parent.Header
+	}
+
+	for i, j := 0, len(missing)-1; i < j; i, j = i+1, j-1 {
+		missing[i], missing[j] = missing[j], missing[i]
+	}
+	return missing, nil
+}
+
+// fetchBlock asks the neighbors for the block with hash through
+// GET /block, taking the first answer that really hashes to it.
+func (bc *Blockchain) fetchBlock(client *http.Client, hash [32]byte) (*block.Block, error) {
+	for _, n := range bc.Neighbors() {
+		endpoint := fmt.Sprintf("http://%s/block?hash=%x", n, hash)
+		resp, err := client.Get(endpoint)
+		if err != nil {
+			log.Printf("ERROR: fetch block from %s: %v", n, err)
+			continue
+		}
+
+		b := new(block.Block)
+		if resp.StatusCode == http.StatusOK {
+			err = json.NewDecoder(resp.Body).Decode(b)
+		} else {
+			err = fmt.Errorf("status %d", resp.StatusCode)
+		}
+		resp.Body.Close()
+		if err != nil || b.CalculateHash() != hash {
+			continue
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("%w: %x", ErrUnknownParent, hash)
+}
@@ -0,0 +1,406 @@
+package blockchain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/big"
+	"net/http"
+	"sync"
+
+	"github.com/Ethical-Ralph/go-block/block"
+)
+
+const (
+	// Headers and blocks are requested in batches of these sizes, which are
+	// also the most GET /headers and GET /blocks return at once.
+	SYNC_HEADERS_PER_REQUEST = 500
+	SYNC_BLOCKS_PER_REQUEST  = 16
+
+	// Block bodies are downloaded SYNC_WINDOW blocks at a time, spread over
+	// SYNC_WORKERS parallel requests, and connected before the next window
+	// starts, so an interrupted sync keeps what it connected.
+	SYNC_WINDOW  = 128
+	SYNC_WORKERS = 4
+)
+
+const (
+	SYNC_STATE_IDLE    = "idle"
+	SYNC_STATE_HEADERS = "headers"
+	SYNC_STATE_BLOCKS  = "blocks"
+	SYNC_STATE_DONE    = "done"
+	SYNC_STATE_FAILED  = "failed"
+)
+
+var ErrSyncRunning = errors.New("sync already running")
+
+// SyncProgress reports the state of the headers-first sync. HeaderHeight is
+// the height of the last validated header, BlockHeight that of the last
+// block body downloaded, and TargetHeight the height of the best header
+// chain found.
+type SyncProgress struct {
+	State        string `json:"state"`
+	Peer         string `json:"peer,omitempty"`
+	HeaderHeight int    `json:"headerHeight"`
+	BlockHeight  int    `json:"blockHeight"`
+	TargetHeight int    `json:"targetHeight"`
+	Error        string `json:"error,omitempty"`
+}
+
+type HeadersResponse struct {
+	Headers []*block.Header `json:"headers"`
+}
+
+type BlocksResponse struct {
+	Blocks []*block.Block `json:"blocks"`
+}
+
+// Headers returns up to count headers of the main chain from height start.
+func (bc *Blockchain) Headers(start int, count int) []*block.Header {
+	bc.muxPool.Lock()
+	defer bc.muxPool.Unlock()
+
+	headers := make([]*block.Header, 0)
+	for h := start; h >= 0 && h < len(bc.chain) && len(headers) < count; h++ {
+		headers = append(headers, bc.chain[h].Header)
+	}
+	return headers
+}
+
+// Blocks returns up to count blocks of the main chain from height start.
+func (bc *Blockchain) Blocks(start int, count int) []*block.Block {
+	bc.muxPool.Lock()
+	defer bc.muxPool.Unlock()
+
+	blocks := make([]*block.Block, 0)
+	for h := start; h >= 0 && h < len(bc.chain) && len(blocks) < count; h++ {
+		blocks = append(blocks, bc.chain[h])
+	}
+	return blocks
+}
+
+func (bc *Blockchain) SyncProgress() SyncProgress {
+	bc.muxSync.Lock()
+	defer bc.muxSync.Unlock()
+
+	return bc.syncProgress
+}
+
+func (bc *Blockchain) updateSyncProgress(update func(p *SyncProgress)) {
+	bc.muxSync.Lock()
+	defer bc.muxSync.Unlock()
+
+	update(&bc.syncProgress)
+}
+
+// StartSync runs a headers-first sync in the background: the best header
+// chain among the neighbors is fetched and validated, then the block bodies
+// it lacks are downloaded in parallel and connected. A sync picks up where
+// the main chain ends, so one that was interrupted resumes from the blocks
+// it already connected.
+func (bc *Blockchain) StartSync() error {
+	bc.muxSync.Lock()
+	defer bc.muxSync.Unlock()
+
+	if s := bc.syncProgress.State; s == SYNC_STATE_HEADERS || s == SYNC_STATE_BLOCKS {
+		return ErrSyncRunning
+	}
+	bc.syncProgress = SyncProgress{State: SYNC_STATE_HEADERS}
+
+	go func() {
+		err := bc.sync()
+		bc.updateSyncProgress(func(p *SyncProgress) {
+			if err != nil {
+				p.State = SYNC_STATE_FAILED
+				p.Error = err.Error()
+				return
+			}
+			p.State = SYNC_STATE_DONE
+		})
+		if err != nil {
+			log.Printf("ERROR: sync: %v", err)
+			return
+		}
+		log.Println("action=sync, status=done")
+	}()
+	return nil
+}
+
+func (bc *Blockchain) sync() error {
+	client := &http.Client{Timeout: NEIGHBOR_REQUEST_TIMEOUT}
+
+	var best []*block.Header = nil
+	var bestWork *big.Int = nil
+	peer := ""
+	for _, n := range bc.Neighbors() {
+		headers, err := bc.fetchHeaderChain(client, n)
+		if err != nil {
+			log.Printf("ERROR: headers from %s: %v", n, err)
+			continue
+		}
+		if work := headerChainWork(headers); best == nil || work.Cmp(bestWork) > 0 {
+			best, bestWork, peer = headers, work, n
+		}
+	}
+
+	bc.mux.Lock()
+	tip := bc.tip()
+	bc.mux.Unlock()
+	if best == nil || bestWork.Cmp(tip.work) <= 0 {
+		bc.updateSyncProgress(func(p *SyncProgress) {
+			p.BlockHeight = int(tip.block.Header.Height)
+			p.TargetHeight = int(tip.block.Header.Height)
+		})
+		log.Println("action=sync, status=up_to_date")
+		return nil
+	}
+
+	bc.updateSyncProgress(func(p *SyncProgress) {
+		p.State = SYNC_STATE_BLOCKS
+		p.Peer = peer
+		p.HeaderHeight = len(best) - 1
+		p.TargetHeight = len(best) - 1
+	})
+	log.Printf("action=sync, peer=%s, target_height=%d", peer, len(best)-1)
+	return bc.downloadBlocks(client, best)
+}
+
+// fetchHeaderChain fetches and validates the main chain headers of the
+// neighbor n. Only the headers past the local tip are fetched when they
+// extend it; otherwise the whole header chain is.
+func (bc *Blockchain) fetchHeaderChain(client *http.Client, n string) ([]*block.Header, error) {
+	ours := bc.Headers(0, math.MaxInt)
+	tip := len(ours) - 1
+
+	page, err := bc.fetchHeaderPage(client, n, tip, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(page) == 1 && page[0].Hash() == ours[tip].Hash() {
+		return bc.fetchHeaders(client, n, ours)
+	}
+	return bc.fetchHeaders(client, n, nil)
+}
+
+// fetchHeaders extends headers, a valid chain from a genesis header, with
+// the headers n has past them, paging through GET /headers. Each page is
+// checked before the next is asked for, so a peer sending bad headers is
+// dropped after one page.
+func (bc *Blockchain) fetchHeaders(client *http.Client, n string, headers []*block.Header) ([]*block.Header, error) {
+	for {
+		page, err := bc.fetchHeaderPage(client, n, len(headers), SYNC_HEADERS_PER_REQUEST)
+		if err != nil {
+			return nil, err
+		}
+
+		from := len(headers)
+		headers = append(headers, page...)
+		if err := bc.checkHeaders(headers, from); err != nil {
+			return nil, err
+		}
+		bc.updateSyncProgress(func(p *SyncProgress) {
+			p.Peer = n
+			p.HeaderHeight = len(headers) - 1
+		})
+		if len(page) < SYNC_HEADERS_PER_REQUEST {
+			return headers, nil
+		}
+	}
+}
+
+// fetchHeaderPage gets up to count headers of n from height start.
+func (bc *Blockchain) fetchHeaderPage(client *http.Client, n string, start int, count int) ([]*block.Header, error) {
+	endpoint := fmt.Sprintf("http://%s/headers?start=%d&count=%d", n, start, count)
+	resp, err := client.Get(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var hr HeadersResponse
+	if err := json.NewDecoder(resp.Body).Decode(&hr); err != nil {
+		return nil, err
+	}
+	if len(hr.Headers) > count {
+		return nil, fmt.Errorf("%d headers asked for, %d sent", count, len(hr.Headers))
+	}
+	for _, h := range hr.Headers {
+		if h == nil {
+			return nil, fmt.Errorf("%w: empty header", ErrInvalidBlock)
+		}
+	}
+	return hr.Headers, nil
+}
+
+// checkHeaders validates headers as a chain from a genesis header, checking
+// only those from height from on. Bodies are checked once downloaded.
+func (bc *Blockchain) checkHeaders(headers []*block.Header, from int) error {
+	if len(headers) == 0 {
+		return fmt.Errorf("%w: no headers", ErrInvalidBlock)
+	}
+	if from == 0 && !validGenesisHeader(headers[0]) {
+		return fmt.Errorf("%w: malformed genesis header", ErrInvalidBlock)
+	}
+
+	for i := from; i < len(headers); i++ {
+		if i == 0 {
+			continue
+		}
+		parent := headers[i-1]
+		difficulty := bc.nextDifficulty(parent, func(back int) *block.Header {
+			return headers[i-1-back]
+		})
+		if err := bc.checkHeader(headers[i], parent, difficulty); err != nil {
+			return fmt.Errorf("header %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func headerChainWork(headers []*block.Header) *big.Int {
+	work := new(big.Int)
+	for _, h := range headers {
+		work.Add(work, blockWork(h))
+	}
+	return work
+}
+
+// downloadBlocks fetches the bodies of headers missing from the block tree
+// and connects them, one window at a time.
+func (bc *Blockchain) downloadBlocks(client *http.Client, headers []*block.Header) error {
+	first := 0
+	bc.mux.Lock()
+	for first < len(headers) {
+		if _, ok := bc.blocks[headers[first].Hash()]; !ok {
+			break
+		}
+		first++
+	}
+	bc.mux.Unlock()
+
+	for start := first; start < len(headers); start += SYNC_WINDOW {
+		end := start + SYNC_WINDOW
+		if end > len(headers) {
+			end = len(headers)
+		}
+
+		blocks, err := bc.downloadWindow(client, headers[start:end], start)
+		if err != nil {
+			return err
+		}
+		if err := bc.connectSynced(blocks); err != nil {
+			return err
+		}
+		bc.updateSyncProgress(func(p *SyncProgress) {
+			p.BlockHeight = end - 1
+		})
+	}
+	return nil
+}
+
+// downloadWindow fetches the bodies of headers, which start at height
+// start. Batches go to the neighbors in turn and move on to the next
+// neighbor when one fails or answers with blocks that do not match the
+// headers.
+func (bc *Blockchain) downloadWindow(client *http.Client, headers []*block.Header, start int) ([]*block.Block, error) {
+	peers := bc.Neighbors()
+	if len(peers) == 0 {
+		return nil, errors.New("no neighbors")
+	}
+
+	blocks := make([]*block.Block, len(headers))
+	batches := make(chan int, (len(headers)+SYNC_BLOCKS_PER_REQUEST-1)/SYNC_BLOCKS_PER_REQUEST)
+	for i := 0; i < len(headers); i += SYNC_BLOCKS_PER_REQUEST {
+		batches <- i
+	}
+	close(batches)
+
+	var wg sync.WaitGroup
+	var muxErr sync.Mutex
+	var firstErr error = nil
+	for w := 0; w < SYNC_WORKERS; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range batches {
+				end := i + SYNC_BLOCKS_PER_REQUEST
+				if end > len(headers) {
+					end = len(headers)
+				}
+
+				var err error
+				for try := 0; try < len(peers); try++ {
+					n := peers[(i/SYNC_BLOCKS_PER_REQUEST+try)%len(peers)]
+					if err = bc.fetchBlocks(client, n, headers[i:end], start+i, blocks[i:end]); err == nil {
+						break
+					}
+					log.Printf("ERROR: blocks %d-%d from %s: %v", start+i, start+end-1, n, err)
+				}
+				if err != nil {
+					muxErr.Lock()
+					if firstErr == nil {
+						firstErr = fmt.Errorf("blocks %d-%d: %w", start+i, start+end-1, err)
+					}
+					muxErr.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return blocks, firstErr
+}
+
+// fetchBlocks fills blocks with the bodies of headers from n through
+// GET /blocks, checking every block against its header hash.
+func (bc *Blockchain) fetchBlocks(client *http.Client, n string, headers []*block.Header, start int, blocks []*block.Block) error {
+	endpoint := fmt.Sprintf("http://%s/blocks?start=%d&count=%d", n, start, len(headers))
+	resp, err := client.Get(endpoint)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var br BlocksResponse
+	if err := json.NewDecoder(resp.Body).Decode(&br); err != nil {
+		return err
+	}
+	if len(br.Blocks) != len(headers) {
+		return fmt.Errorf("got %d blocks, want %d", len(br.Blocks), len(headers))
+	}
+	for i, b := range br.Blocks {
+		if b == nil {
+			return fmt.Errorf("%w: block %d is empty", ErrInvalidBlock, start+i)
+		}
+		if b.CalculateHash() != headers[i].Hash() {
+			return fmt.Errorf("%w: block %d does not match its header", ErrInvalidBlock, start+i)
+		}
+	}
+	copy(blocks, br.Blocks)
+	return nil
+}
+
+// connectSynced adds downloaded blocks to the block tree in order and moves
+// the main chain onto them once their branch has the most work.
+func (bc *Blockchain) connectSynced(blocks []*block.Block) error {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+
+	for _, b := range blocks {
+		if _, err := bc.addBlock(b); err != nil {
+			return fmt.Errorf("block %d: %w", b.Header.Height, err)
+		}
+	}
+
+	bc.muxPool.Lock()
+	defer bc.muxPool.Unlock()
+
+	bc.activateBestChain()
+	for _, b := range blocks {
+		if bc.invalidBlocks[b.CalculateHash()] {
+			return fmt.Errorf("%w: block %d does not connect", ErrInvalidBlock, b.Header.Height)
+		}
+	}
+	return nil
+}
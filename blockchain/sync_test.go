@@ -0,0 +1,38 @@
+package blockchain
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Ethical-Ralph/go-block/block"
+	"github.com/Ethical-Ralph/go-block/store"
+)
+
+func TestFetchBlocksRejectsEmpty(t *testing.T) {
+	bc := newTestBlockchain(t, store.NewMemoryStore())
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(`{"blocks":[null]}`))
+	}))
+	defer srv.Close()
+
+	headers := []*block.Header{bc.Chain()[0].Header}
+	blocks := make([]*block.Block, 1)
+	err := bc.fetchBlocks(srv.Client(), strings.TrimPrefix(srv.URL, "http://"), headers, 0, blocks)
+	if !errors.Is(err, ErrInvalidBlock) {
+		t.Errorf("fetchBlocks of an empty block = %v, want %v", err, ErrInvalidBlock)
+	}
+	if blocks[0] != nil {
+		t.Error("empty block passed on")
+	}
+}
+
+func TestUnmarshalEmptyBlock(t *testing.T) {
+	var bc Blockchain
+	if err := json.Unmarshal([]byte(`{"chains":[null]}`), &bc); err == nil {
+		t.Error("chain with an empty block accepted")
+	}
+}
@@ -0,0 +1,317 @@
+package blockchain
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"math/big"
+	"time"
+
+	"github.com/Ethical-Ralph/go-block/block"
+	"github.com/Ethical-Ralph/go-block/transaction"
+)
+
+// REORG_HISTORY is how many reorg events are kept for GET /reorgs.
+const REORG_HISTORY = 100
+
+// treeNode is a block in the block tree. Work is the cumulative work of the
+// branch ending in the block: the expected number of hashes, 2^difficulty,
+// summed over the block and all its ancestors.
+type treeNode struct {
+	block    *block.Block
+	hash     [32]byte
+	parent   *treeNode
+	children []*treeNode
+	work     *big.Int
+}
+
+// ReorgEvent records one switch of the main chain to another branch.
+// ForkHeight is the height of the last block both branches share, or -1 if
+// they do not even share the genesis block. Returned counts the
+// transactions of disconnected blocks that went back into the pool.
+type ReorgEvent struct {
+	Time         int64  `json:"time"`
+	OldTip       string `json:"oldTip"`
+	NewTip       string `json:"newTip"`
+	ForkHeight   int    `json:"forkHeight"`
+	Disconnected int    `json:"disconnected"`
+	Connected    int    `json:"connected"`
+	Returned     int    `json:"returned"`
+}
+
+// blockWork is the work a header proves. A genesis block proves none: it is
+// not mined, so a peer's genesis alone never outweighs a chain of ours.
+func blockWork(h *block.Header) *big.Int {
+	if h.Height == 0 {
+		return new(big.Int)
+	}
+	return new(big.Int).Lsh(big.NewInt(1), uint(h.Difficulty))
+}
+
+// addNode puts b in the tree below parent, which is nil for a genesis block.
+// The caller must hold mux or own bc exclusively.
+func (bc *Blockchain) addNode(b *block.Block, parent *treeNode) *treeNode {
+	n := &treeNode{block: b, hash: b.CalculateHash(), parent: parent, work: blockWork(b.Header)}
+	if parent != nil {
+		n.work.Add(n.work, parent.work)
+		parent.children = append(parent.children, n)
+	}
+	bc.blocks[n.hash] = n
+	return n
+}
+
+// tip is the tree node of the last block of the main chain.
+func (bc *Blockchain) tip() *treeNode {
+	return bc.blocks[bc.LastBlock().CalculateHash()]
+}
+
+// addBlock puts a block from a peer in the tree after checking its header
+// against its parent, which must be in the tree already, and its
+// transactions against the merkle root. The transactions themselves are
+// only checked once its branch gets connected. Blocks already in the tree
+// are returned as they are. The caller must hold mux.
+func (bc *Blockchain) addBlock(b *block.Block) (*treeNode, error) {
+	hash := b.CalculateHash()
+	if n, ok := bc.blocks[hash]; ok {
+		return n, nil
+	}
+	if bc.invalidBlocks[hash] {
+		return nil, fmt.Errorf("%w: %x was rejected before", ErrInvalidBlock, hash)
+	}
+
+	if b.Header.Height == 0 {
+		if !validGenesis(b) {
+			return nil, fmt.Errorf("%w: malformed genesis block", ErrInvalidBlock)
+		}
+		return bc.addNode(b, nil), nil
+	}
+
+	parent, ok := bc.blocks[b.Header.PrevHash]
+	if !ok {
+		return nil, fmt.Errorf("%w: %x", ErrUnknownParent, b.Header.PrevHash)
+	}
+	err := bc.checkHeader(b.Header, parent.block.Header, bc.nextDifficulty(parent.block.Header, func(back int) *block.Header {
+		n := parent
+		for ; back > 0; back-- {
+			n = n.parent
+		}
+		return n.block.Header
+	}))
+	if err != nil {
+		return nil, err
+	}
+	if b.Header.MerkleRoot != block.MerkleRoot(b.Transactions) {
+		// Not remembered as invalid: the header may be genuine and the
+		// transactions altered on the way here.
+		return nil, fmt.Errorf("%w: merkle root does not match transactions", ErrInvalidBlock)
+	}
+	return bc.addNode(b, parent), nil
+}
+
+// bestTip is the node with the most cumulative work. The current tip wins
+// ties, so the main chain only moves for strictly more work; other ties go
+// to the lower hash, so every node picks the same branch.
+func (bc *Blockchain) bestTip() *treeNode {
+	tip := bc.tip()
+	best := tip
+	for _, n := range bc.blocks {
+		c := n.work.Cmp(best.work)
+		if c > 0 || (c == 0 && best != tip && bytes.Compare(n.hash[:], best.hash[:]) < 0) {
+			best = n
+		}
+	}
+	return best
+}
+
+// activateBestChain reorganizes the main chain onto the best tip in the
+// tree. A branch holding an invalid block is dropped and the next best tip
+// is tried. It reports whether the tip changed. The caller must hold mux and
+// muxPool.
+func (bc *Blockchain) activateBestChain() bool {
+	old := bc.tip()
+	for {
+		best := bc.bestTip()
+		if best == bc.tip() {
+			break
+		}
+		if err := bc.reorganize(best); err != nil {
+			log.Printf("ERROR: connect branch to %x: %v", best.hash, err)
+			if _, ok := bc.blocks[best.hash]; ok {
+				// Valid but not stored: keep the old chain for now.
+				break
+			}
+		}
+	}
+	return bc.tip() != old
+}
+
+// reorganize makes target the tip of the main chain. Blocks down to the
+// last one shared with target's branch are disconnected and their
+// transactions, other than coinbases, go back to the pool; then target's
+// branch is connected block by block. If one of its blocks turns out
+// invalid, it is dropped from the tree together with its descendants, and
+// the old main chain is restored. So is it when the store fails to write the
+// new branch. The caller must hold mux and muxPool.
+func (bc *Blockchain) reorganize(target *treeNode) error {
+	old := bc.tip()
+	fork := commonAncestor(old, target)
+	forkHeight := -1
+	if fork != nil {
+		forkHeight = int(fork.block.Header.Height)
+	}
+
+	disconnected := make([]*block.Block, 0)
+	for len(bc.chain) > forkHeight+1 {
+		disconnected = append(disconnected, bc.disconnectBlock())
+	}
+	restore := func() {
+		for len(bc.chain) > forkHeight+1 {
+			bc.disconnectBlock()
+		}
+		for j := len(disconnected) - 1; j >= 0; j-- {
+			bc.connectBlock(disconnected[j])
+		}
+	}
+
+	branch := make([]*treeNode, 0)
+	for n := target; n != fork; n = n.parent {
+		branch = append(branch, n)
+	}
+	connected := make([]*block.Block, 0, len(branch))
+	for i := len(branch) - 1; i >= 0; i-- {
+		b := branch[i].block
+		if b.Header.Height > 0 {
+			if err := bc.checkBlock(b, bc.seenTransactions, bc.confirmedNonces, bc.utxos); err != nil {
+				bc.invalidate(branch[i])
+				restore()
+				return fmt.Errorf("block %d: %w", b.Header.Height, err)
+			}
+		}
+		bc.connectBlock(b)
+		connected = append(connected, b)
+	}
+
+	if err := bc.storeBranch(forkHeight+1, connected); err != nil {
+		restore()
+		if err := bc.storeBranch(forkHeight+1, bc.chain[forkHeight+1:]); err != nil {
+			log.Printf("ERROR: restore store at %d: %v", forkHeight+1, err)
+		}
+		return err
+	}
+
+	for _, b := range connected {
+		bc.mempool.RemoveIncluded(b.Transactions)
+	}
+	returned := bc.returnToPool(disconnected)
+	bc.revalidatePool()
+
+	if len(disconnected) == 0 {
+		return nil
+	}
+	e := &ReorgEvent{
+		Time:         time.Now().UnixNano(),
+		OldTip:       fmt.Sprintf("%x", old.hash),
+		NewTip:       fmt.Sprintf("%x", target.hash),
+		ForkHeight:   forkHeight,
+		Disconnected: len(disconnected),
+		Connected:    len(connected),
+	}
+	for _, t := range returned {
+		if bc.mempool.Has(t.ID()) {
+			e.Returned++
+		}
+	}
+	bc.reorgs = append(bc.reorgs, e)
+	if len(bc.reorgs) > REORG_HISTORY {
+		bc.reorgs = bc.reorgs[len(bc.reorgs)-REORG_HISTORY:]
+	}
+	log.Printf("action=reorg, old_tip=%s, new_tip=%s, fork_height=%d, disconnected=%d, connected=%d, returned=%d",
+		e.OldTip, e.NewTip, e.ForkHeight, e.Disconnected, e.Connected, e.Returned)
+	return nil
+}
+
+// storeBranch replaces the stored blocks from height on with branch.
+func (bc *Blockchain) storeBranch(height int, branch []*block.Block) error {
+	if err := bc.store.Truncate(height); err != nil {
+		return fmt.Errorf("truncate store at %d: %w", height, err)
+	}
+	for _, b := range branch {
+		if err := bc.store.AppendBlock(b); err != nil {
+			return fmt.Errorf("store block %d: %w", b.Header.Height, err)
+		}
+	}
+	return nil
+}
+
+// returnToPool puts the transactions of disconnected blocks, given newest
+// first, back into the pool, oldest first, unless the new main chain holds
+// them already. Coinbases are gone with their block. The pool must be
+// revalidated afterwards. The caller must hold muxPool.
+func (bc *Blockchain) returnToPool(disconnected []*block.Block) []*transaction.Transaction {
+	returned := make([]*transaction.Transaction, 0)
+	for i := len(disconnected) - 1; i >= 0; i-- {
+		for _, t := range disconnected[i].Transactions {
+			if t.SenderBlockchainAddress == MINNING_SENDER || bc.seenTransactions[t.ID()] {
+				continue
+			}
+			evicted, err := bc.mempool.Add(t)
+			if err != nil {
+				log.Printf("ERROR: return transaction %s to pool: %v", t.IDString(), err)
+				continue
+			}
+			for _, e := range evicted {
+				log.Printf("action=mempool_evict, transaction=%s", e.IDString())
+			}
+			returned = append(returned, t)
+		}
+	}
+	return returned
+}
+
+// invalidate drops n and everything built on it from the tree and remembers
+// them, so peers cannot offer them again. Blocks only enter the tree with the
+// transactions their header commits to, so an invalid one is invalid
+// whoever relays it. The caller must hold mux.
+func (bc *Blockchain) invalidate(n *treeNode) {
+	if p := n.parent; p != nil {
+		for i, c := range p.children {
+			if c == n {
+				p.children = append(p.children[:i], p.children[i+1:]...)
+				break
+			}
+		}
+	}
+
+	stack := []*treeNode{n}
+	for len(stack) > 0 {
+		last := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		bc.invalidBlocks[last.hash] = true
+		delete(bc.blocks, last.hash)
+		stack = append(stack, last.children...)
+	}
+}
+
+// commonAncestor is the last block a and b share, or nil if they grow from
+// different genesis blocks.
+func commonAncestor(a *treeNode, b *treeNode) *treeNode {
+	for a != nil && b != nil && a != b {
+		if a.block.Header.Height >= b.block.Header.Height {
+			a = a.parent
+		} else {
+			b = b.parent
+		}
+	}
+	if a != b {
+		return nil
+	}
+	return a
+}
+
+// Reorgs lists the most recent reorg events, oldest first.
+func (bc *Blockchain) Reorgs() []*ReorgEvent {
+	bc.muxPool.Lock()
+	defer bc.muxPool.Unlock()
+
+	return append([]*ReorgEvent{}, bc.reorgs...)
+}
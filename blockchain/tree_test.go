@@ -0,0 +1,312 @@
+package blockchain
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Ethical-Ralph/go-block/amount"
+	"github.com/Ethical-Ralph/go-block/block"
+	"github.com/Ethical-Ralph/go-block/store"
+	"github.com/Ethical-Ralph/go-block/transaction"
+	"github.com/Ethical-Ralph/go-block/utils"
+	"github.com/Ethical-Ralph/go-block/utxo"
+	"github.com/Ethical-Ralph/go-block/wallet"
+)
+
+var errStoreFailed = errors.New("store failed")
+
+// failStore is a MemoryStore whose writes fail while fail is set.
+type failStore struct {
+	*store.MemoryStore
+	fail bool
+}
+
+func (fs *failStore) AppendBlock(b *block.Block) error {
+	if fs.fail {
+		return errStoreFailed
+	}
+	return fs.MemoryStore.AppendBlock(b)
+}
+
+func newTestBlockchain(t *testing.T, s store.Store) *Blockchain {
+	t.Helper()
+	bc, err := NewBlockchain(wallet.NewWallet().BlockchainAddress(), 0, s, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bc
+}
+
+// mine builds a block on parent holding transactions and a coinbase paying
+// miner, without adding it anywhere.
+func mine(bc *Blockchain, parent *block.Block, miner string, transactions ...*transaction.Transaction) *block.Block {
+	height := parent.Header.Height + 1
+	reward, _ := blockReward(transactions)
+	transactions = append(transactions,
+		transaction.NewTransaction(MINNING_SENDER, miner, reward, 0, height, time.Now().UnixNano()))
+
+	h := block.NewHeader(height, parent.CalculateHash(), transactions, MINNING_DIFFICULTY)
+	if h.Timestamp <= parent.Header.Timestamp {
+		h.Timestamp = parent.Header.Timestamp + 1
+	}
+	bc.ProofOfWork(h)
+	return block.NewBlock(h, transactions)
+}
+
+// pay signs a transaction of w spending inputs for value and fee, with the
+// rest of the inputs going back to w as change.
+func pay(w *wallet.Wallet, recipient string, value amount.Amount, fee amount.Amount, nonce uint64,
+	inputs ...utxo.Entry) *transaction.Transaction {
+	t := transaction.NewTransaction(w.BlockchainAddress(), recipient, value, fee, nonce, time.Now().UnixNano())
+	var total amount.Amount = 0
+	for _, e := range inputs {
+		t.Inputs = append(t.Inputs, e.OutPoint)
+		total += e.Output.Value
+	}
+	t.Change = total - value - fee
+
+	m, _ := t.SigningPayload()
+	h := sha256.Sum256(m)
+	r, s, _ := ecdsa.Sign(rand.Reader, w.PrivateKey(), h[:])
+	t.SenderPublicKey = utils.PublicKeyToString(w.PublicKey())
+	t.Signature = (&utils.Signature{R: r, S: s}).String()
+	return t
+}
+
+func receive(t *testing.T, bc *Blockchain, blocks ...*block.Block) {
+	t.Helper()
+	for _, b := range blocks {
+		if _, err := bc.ReceiveBlock(b); err != nil {
+			t.Fatalf("ReceiveBlock(%d): %v", b.Header.Height, err)
+		}
+	}
+}
+
+func tipHash(bc *Blockchain) [32]byte {
+	chain := bc.Chain()
+	return chain[len(chain)-1].CalculateHash()
+}
+
+func checkStored(t *testing.T, bc *Blockchain, s store.Store) {
+	t.Helper()
+	stored, err := s.Blocks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := bc.Chain()
+	if len(stored) != len(chain) {
+		t.Fatalf("store holds %d blocks, chain %d", len(stored), len(chain))
+	}
+	for i := range chain {
+		if stored[i].CalculateHash() != chain[i].CalculateHash() {
+			t.Errorf("stored block %d is not the one on the chain", i)
+		}
+	}
+}
+
+func TestReorgToMoreWork(t *testing.T) {
+	s := store.NewMemoryStore()
+	bc := newTestBlockchain(t, s)
+	genesis := bc.Chain()[0]
+	alice, bob, carol := wallet.NewWallet(), wallet.NewWallet(), wallet.NewWallet()
+
+	a1 := mine(bc, genesis, alice.BlockchainAddress())
+	receive(t, bc, a1)
+
+	// Alice pays Carol on one branch; Bob mines a competing one.
+	payment := pay(alice, carol.BlockchainAddress(), amount.COIN/2, amount.COIN/10, 0,
+		bc.SpendableOutputs(alice.BlockchainAddress())...)
+	if err := bc.AddTransaction(payment); err != nil {
+		t.Fatal(err)
+	}
+	a2 := mine(bc, a1, alice.BlockchainAddress(), payment)
+	receive(t, bc, a2)
+	if bc.mempool.Has(payment.ID()) {
+		t.Fatal("mined payment still in the pool")
+	}
+	if got := bc.CalculateTotalAmount(carol.BlockchainAddress()); got != amount.COIN/2 {
+		t.Fatalf("Carol has %s before the reorg", got)
+	}
+
+	// As much work as the main chain does not move it.
+	b2 := mine(bc, a1, bob.BlockchainAddress())
+	receive(t, bc, b2)
+	if tipHash(bc) != a2.CalculateHash() {
+		t.Fatal("tip moved to a branch with equal work")
+	}
+
+	b3 := mine(bc, b2, bob.BlockchainAddress())
+	changed, err := bc.ReceiveBlock(b3)
+	if err != nil || !changed {
+		t.Fatalf("ReceiveBlock of the heavier branch = %t, %v", changed, err)
+	}
+	if tipHash(bc) != b3.CalculateHash() || len(bc.Chain()) != 4 {
+		t.Fatalf("tip is not the heavier branch")
+	}
+
+	for _, tt := range []struct {
+		name string
+		w    *wallet.Wallet
+		want amount.Amount
+	}{
+		{"Alice", alice, MINNING_REWARD},
+		{"Bob", bob, 2 * MINNING_REWARD},
+		{"Carol", carol, 0},
+	} {
+		if got := bc.CalculateTotalAmount(tt.w.BlockchainAddress()); got != tt.want {
+			t.Errorf("%s has %s, want %s", tt.name, got, tt.want)
+		}
+	}
+	if _, pending := bc.Balances(carol.BlockchainAddress()); pending != amount.COIN/2 {
+		t.Errorf("Carol's pending balance is %s, want the returned payment", pending)
+	}
+
+	if !bc.mempool.Has(payment.ID()) {
+		t.Error("disconnected payment did not return to the pool")
+	}
+	if got := bc.NextNonce(alice.BlockchainAddress()); got != 1 {
+		t.Errorf("NextNonce of Alice = %d, want 1 with the payment pending", got)
+	}
+	reorgs := bc.Reorgs()
+	if len(reorgs) != 1 {
+		t.Fatalf("%d reorgs recorded, want 1", len(reorgs))
+	}
+	if e := reorgs[0]; e.ForkHeight != 1 || e.Disconnected != 1 || e.Connected != 2 || e.Returned != 1 {
+		t.Errorf("reorg event %+v", e)
+	}
+	checkStored(t, bc, s)
+}
+
+func TestReorgDropsStaleTransactions(t *testing.T) {
+	bc := newTestBlockchain(t, store.NewMemoryStore())
+	genesis := bc.Chain()[0]
+	alice, bob := wallet.NewWallet(), wallet.NewWallet()
+
+	// The coinbase Alice spends is only on the branch about to lose.
+	a1 := mine(bc, genesis, alice.BlockchainAddress())
+	receive(t, bc, a1)
+	payment := pay(alice, bob.BlockchainAddress(), amount.COIN/2, 0, 0,
+		bc.SpendableOutputs(alice.BlockchainAddress())...)
+	if err := bc.AddTransaction(payment); err != nil {
+		t.Fatal(err)
+	}
+
+	b1 := mine(bc, genesis, bob.BlockchainAddress())
+	b2 := mine(bc, b1, bob.BlockchainAddress())
+	receive(t, bc, b1, b2)
+	if tipHash(bc) != b2.CalculateHash() {
+		t.Fatal("tip is not the heavier branch")
+	}
+	if bc.mempool.Has(payment.ID()) {
+		t.Error("payment spending a disconnected coinbase stayed in the pool")
+	}
+	if got := bc.CalculateTotalAmount(alice.BlockchainAddress()); got != 0 {
+		t.Errorf("Alice has %s, want 0", got)
+	}
+}
+
+func TestReorgInvalidBlockRestores(t *testing.T) {
+	s := store.NewMemoryStore()
+	bc := newTestBlockchain(t, s)
+	genesis := bc.Chain()[0]
+	alice, mallory := wallet.NewWallet(), wallet.NewWallet()
+
+	a1 := mine(bc, genesis, alice.BlockchainAddress())
+	receive(t, bc, a1)
+
+	// B1 has a valid header and merkle root, but spends coins that do not
+	// exist. Its child B2 gives its branch more work.
+	forged := pay(mallory, mallory.BlockchainAddress(), amount.COIN, 0, 0, utxo.Entry{
+		OutPoint: transaction.OutPoint{TxID: sha256.Sum256([]byte("nothing")), Index: 0},
+		Output:   transaction.Output{Address: mallory.BlockchainAddress(), Value: amount.COIN},
+	})
+	b1 := mine(bc, genesis, mallory.BlockchainAddress(), forged)
+	b2 := mine(bc, b1, mallory.BlockchainAddress())
+	receive(t, bc, b1)
+	if _, err := bc.ReceiveBlock(b2); !errors.Is(err, ErrInvalidBlock) {
+		t.Fatalf("ReceiveBlock of a branch with an invalid block = %v, want %v", err, ErrInvalidBlock)
+	}
+
+	if tipHash(bc) != a1.CalculateHash() || len(bc.Chain()) != 2 {
+		t.Fatal("old chain not restored")
+	}
+	if got := bc.CalculateTotalAmount(alice.BlockchainAddress()); got != MINNING_REWARD {
+		t.Errorf("Alice has %s, want %s", got, MINNING_REWARD)
+	}
+	if got := bc.CalculateTotalAmount(mallory.BlockchainAddress()); got != 0 {
+		t.Errorf("Mallory has %s, want 0", got)
+	}
+	if len(bc.Reorgs()) != 0 {
+		t.Error("failed reorg recorded")
+	}
+	for _, b := range []*block.Block{b1, b2} {
+		if bc.hasBlock(b.CalculateHash()) || !bc.invalidBlocks[b.CalculateHash()] {
+			t.Errorf("invalid block %d still in the tree", b.Header.Height)
+		}
+	}
+	if _, err := bc.ReceiveBlock(b1); !errors.Is(err, ErrInvalidBlock) {
+		t.Errorf("ReceiveBlock of a rejected block = %v, want %v", err, ErrInvalidBlock)
+	}
+	checkStored(t, bc, s)
+}
+
+func TestReorgStoreFailureRestores(t *testing.T) {
+	s := &failStore{MemoryStore: store.NewMemoryStore()}
+	bc := newTestBlockchain(t, s)
+	genesis := bc.Chain()[0]
+	alice, bob := wallet.NewWallet(), wallet.NewWallet()
+
+	a1 := mine(bc, genesis, alice.BlockchainAddress())
+	receive(t, bc, a1)
+
+	b1 := mine(bc, genesis, bob.BlockchainAddress())
+	b2 := mine(bc, b1, bob.BlockchainAddress())
+	receive(t, bc, b1)
+	s.fail = true
+	if changed, _ := bc.ReceiveBlock(b2); changed {
+		t.Fatal("tip moved to a branch the store did not take")
+	}
+	if tipHash(bc) != a1.CalculateHash() {
+		t.Fatal("old chain not restored")
+	}
+	if got := bc.CalculateTotalAmount(bob.BlockchainAddress()); got != 0 {
+		t.Errorf("Bob has %s, want 0", got)
+	}
+	if !bc.hasBlock(b2.CalculateHash()) {
+		t.Fatal("valid block dropped from the tree after a store failure")
+	}
+
+	// Once the store works again the branch is connected.
+	s.fail = false
+	bc.mux.Lock()
+	bc.muxPool.Lock()
+	changed := bc.activateBestChain()
+	bc.muxPool.Unlock()
+	bc.mux.Unlock()
+	if !changed || tipHash(bc) != b2.CalculateHash() {
+		t.Fatal("tip did not move once the store recovered")
+	}
+	if got := bc.CalculateTotalAmount(bob.BlockchainAddress()); got != 2*MINNING_REWARD {
+		t.Errorf("Bob has %s, want %s", got, 2*MINNING_REWARD)
+	}
+	checkStored(t, bc, s)
+}
+
+func TestBestTipTieBreak(t *testing.T) {
+	bc := newTestBlockchain(t, store.NewMemoryStore())
+	genesis := bc.Chain()[0]
+
+	// Neither side branch beats the tip, however their hashes compare.
+	a1 := mine(bc, genesis, wallet.NewWallet().BlockchainAddress())
+	receive(t, bc, a1)
+	for i := 0; i < 2; i++ {
+		receive(t, bc, mine(bc, genesis, wallet.NewWallet().BlockchainAddress()))
+	}
+	if tipHash(bc) != a1.CalculateHash() {
+		t.Error("tip moved to a branch with equal work")
+	}
+}
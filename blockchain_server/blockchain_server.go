@@ -1,45 +1,116 @@
 package blockchainserver
 
 import (
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"io"
 	"log"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strconv"
+	"time"
 
+	"github.com/Ethical-Ralph/go-block/block"
 	"github.com/Ethical-Ralph/go-block/blockchain"
+	"github.com/Ethical-Ralph/go-block/mempool"
+	"github.com/Ethical-Ralph/go-block/store"
 	"github.com/Ethical-Ralph/go-block/transaction"
 	"github.com/Ethical-Ralph/go-block/utils"
+	"github.com/Ethical-Ralph/go-block/utxo"
 	"github.com/Ethical-Ralph/go-block/wallet"
 )
 
 var cache map[string]*blockchain.Blockchain = make(map[string]*blockchain.Blockchain)
 
 type BlockchainServer struct {
-	port uint16
+	port         uint16
+	dataDir      string
+	minerAddress string
+
+	maxBlockTransactions int
+	maxBlockBytes        int
+	targetInterval       time.Duration
+	mineEmptyBlocks      bool
 }
 
-func NewBlockchainServer(port uint16) *BlockchainServer {
-	return &BlockchainServer{port}
+// NewBlockchainServer creates a node that pays mining rewards to
+// minerAddress, or to the wallet in its keystore when minerAddress is empty,
+// and mines blocks of at most maxBlockTransactions transactions and
+// maxBlockBytes bytes, retargeting difficulty towards targetInterval. With
+// mineEmptyBlocks unset it only mines while transactions are pending.
+func NewBlockchainServer(port uint16, dataDir string, minerAddress string,
+	maxBlockTransactions int, maxBlockBytes int, targetInterval time.Duration, mineEmptyBlocks bool) *BlockchainServer {
+	return &BlockchainServer{port, dataDir, minerAddress, maxBlockTransactions, maxBlockBytes, targetInterval, mineEmptyBlocks}
 }
 
 func (bcs *BlockchainServer) Port() uint16 {
 	return bcs.port
 }
 
+// DataDir is where this node keeps its files. Nodes sharing a data directory
+// get a subdirectory per port.
+func (bcs *BlockchainServer) DataDir() string {
+	return filepath.Join(bcs.dataDir, strconv.Itoa(int(bcs.Port())))
+}
+
 func (bcs *BlockchainServer) GetBlockchain() *blockchain.Blockchain {
 	bc, ok := cache["blockchain"]
 	if !ok {
-		minersWallet := wallet.NewWallet()
-		bc = blockchain.NewBlockchain(minersWallet.BlockchainAddress(), bcs.Port())
+		s, err := store.OpenFileStore(filepath.Join(bcs.DataDir(), "chain.log"))
+		if err != nil {
+			log.Fatalf("ERROR: open chain store: %v", err)
+		}
+
+		minerAddress := bcs.minerAddress
+		if minerAddress != "" {
+			if err := wallet.ValidateAddress(minerAddress); err != nil {
+				log.Fatalf("ERROR: miner address: %v", err)
+			}
+		} else {
+			minersWallet, err := bcs.MinerWallet()
+			if err != nil {
+				log.Fatalf("ERROR: load miner wallet: %v", err)
+			}
+			minerAddress = minersWallet.BlockchainAddress()
+		}
+
+		bc, err = blockchain.NewBlockchain(minerAddress, bcs.Port(), s, bcs.targetInterval)
+		if err != nil {
+			log.Fatalf("ERROR: load blockchain: %v", err)
+		}
+		bc.SetBlockLimits(bcs.maxBlockTransactions, bcs.maxBlockBytes)
+		bc.SetMineEmptyBlocks(bcs.mineEmptyBlocks)
 		cache["blockchain"] = bc
-		log.Printf(("private_key %v"), minersWallet.PrivateKey())
-		log.Printf(("public_key %v"), minersWallet.PublicKey())
-		log.Printf(("blockchain_address %v"), minersWallet.BlockchainAddress())
+		log.Printf(("blockchain_address %v"), minerAddress)
 	}
 	return bc
 }
 
+// MinerWallet loads the node's wallet from the keystore in DataDir, creating
+// and saving a new one on first start so rewards stay spendable across
+// restarts.
+func (bcs *BlockchainServer) MinerWallet() (*wallet.Wallet, error) {
+	path := filepath.Join(bcs.DataDir(), "miner.json")
+
+	w, err := wallet.LoadWallet(path)
+	if err == nil {
+		log.Printf("action=load_miner_wallet, keystore=%s", path)
+		return w, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	w = wallet.NewWallet()
+	if err := w.Save(path); err != nil {
+		return nil, err
+	}
+	log.Printf("action=create_miner_wallet, keystore=%s", path)
+	return w, nil
+}
+
 func (bcs *BlockchainServer) GetChain(w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case http.MethodGet:
@@ -71,8 +142,11 @@ func (bcs *BlockchainServer) Transactions(w http.ResponseWriter, req *http.Reque
 		io.WriteString(w, string(m[:]))
 		return
 
-	case http.MethodPost:
+	// POST comes from wallets, PUT from neighbors relaying a transaction
+	// they accepted. Both are verified and relayed the same way.
+	case http.MethodPost, http.MethodPut:
 		{
+			w.Header().Add("Content-Type", "application/json")
 			decoder := json.NewDecoder(req.Body)
 
 			var t blockchain.TransactionRequest
@@ -80,35 +154,29 @@ func (bcs *BlockchainServer) Transactions(w http.ResponseWriter, req *http.Reque
 			err := decoder.Decode(&t)
 			if err != nil {
 				log.Printf("ERROR: %v", err)
-				io.WriteString(w, string(utils.JsonStatus("fail")))
+				w.WriteHeader(http.StatusBadRequest)
+				io.WriteString(w, string(utils.JsonError("fail", "malformed_request")))
 				return
 			}
 
 			if !t.Validate() {
 				log.Println("ERROR: missing fields")
-				io.WriteString(w, string(utils.JsonStatus("field validation failed")))
+				w.WriteHeader(http.StatusBadRequest)
+				io.WriteString(w, string(utils.JsonError("field validation failed", "missing_fields")))
 				return
 			}
 
-			publicKey := utils.PublicKeyFromString(*t.SenderPublicKey)
-			signature := utils.SignatureFromString(*t.Signature)
-
-			bc := bcs.GetBlockchain()
-
-			isCreated := bc.CreateTransaction(*t.SenderBlockchainAddress, *t.RecipientBlockchainAddress,
-				*t.Value, publicKey, signature)
-
-			w.Header().Add("Content-Type", "application/json")
-			var m []byte
-
-			if !isCreated {
-				w.WriteHeader(http.StatusBadGateway)
-				m = utils.JsonStatus("Fail")
-			} else {
-				w.WriteHeader(http.StatusCreated)
-				m = utils.JsonStatus("success")
+			err = bcs.createTransaction(&t)
+			if err != nil {
+				log.Printf("ERROR: %v", err)
+				status, message, reason := transactionErrorStatus(err)
+				w.WriteHeader(status)
+				io.WriteString(w, string(utils.JsonError(message, reason)))
+				return
 			}
 
+			w.WriteHeader(http.StatusCreated)
+			m := utils.JsonStatus("success")
 			io.WriteString(w, string(m))
 		}
 
@@ -118,6 +186,60 @@ func (bcs *BlockchainServer) Transactions(w http.ResponseWriter, req *http.Reque
 	}
 }
 
+// createTransaction turns a validated request into a signed transaction.
+// The key and signature are parsed by the blockchain before any signature
+// check, so malformed input never reaches the curve arithmetic.
+func (bcs *BlockchainServer) createTransaction(t *blockchain.TransactionRequest) error {
+	tx := transaction.NewTransaction(*t.SenderBlockchainAddress, *t.RecipientBlockchainAddress,
+		*t.Value, 0, *t.Nonce, *t.Timestamp)
+	if t.Fee != nil {
+		tx.Fee = *t.Fee
+	}
+	if t.Change != nil {
+		tx.Change = *t.Change
+	}
+	if t.Inputs != nil {
+		tx.Inputs = *t.Inputs
+	}
+	tx.SenderPublicKey = *t.SenderPublicKey
+	tx.Signature = *t.Signature
+
+	bc := bcs.GetBlockchain()
+	return bc.CreateTransaction(tx)
+}
+
+// transactionErrorStatus maps an error from createTransaction to the HTTP
+// status, message and reason code returned to the client.
+func transactionErrorStatus(err error) (int, string, string) {
+	switch {
+	case errors.Is(err, utils.ErrMalformedPublicKey):
+		return http.StatusBadRequest, "malformed public key", "malformed_public_key"
+	case errors.Is(err, utils.ErrMalformedSignature):
+		return http.StatusBadRequest, "malformed signature", "malformed_signature"
+	case errors.Is(err, wallet.ErrInvalidAddress):
+		return http.StatusBadRequest, "invalid blockchain address", "invalid_address"
+	case errors.Is(err, blockchain.ErrSenderMismatch):
+		return http.StatusUnprocessableEntity, "sender does not match public key", "sender_mismatch"
+	case errors.Is(err, blockchain.ErrInvalidSignature):
+		return http.StatusUnprocessableEntity, "invalid signature", "invalid_signature"
+	case errors.Is(err, blockchain.ErrInvalidNonce):
+		return http.StatusUnprocessableEntity, "transaction nonce out of order", "invalid_nonce"
+	case errors.Is(err, utxo.ErrInsufficientInputs):
+		return http.StatusUnprocessableEntity, "insufficient funds", "insufficient_funds"
+	case errors.Is(err, utxo.ErrMissingInput), errors.Is(err, utxo.ErrInputNotOwned),
+		errors.Is(err, utxo.ErrDuplicateInput):
+		return http.StatusUnprocessableEntity, "invalid inputs", "invalid_inputs"
+	case errors.Is(err, utxo.ErrUnbalanced):
+		return http.StatusUnprocessableEntity, "inputs do not match outputs and fee", "unbalanced"
+	case errors.Is(err, mempool.ErrPoolFull):
+		return http.StatusServiceUnavailable, "mempool full, fee too low", "mempool_full"
+	case errors.Is(err, blockchain.ErrTransactionSeen), errors.Is(err, mempool.ErrDuplicate):
+		return http.StatusUnprocessableEntity, "transaction already seen", "transaction_seen"
+	default:
+		return http.StatusInternalServerError, "fail", "internal_error"
+	}
+}
+
 func (bcs *BlockchainServer) Mine(w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case http.MethodGet:
@@ -160,14 +282,49 @@ func (bcs *BlockchainServer) StartMine(w http.ResponseWriter, req *http.Request)
 	}
 }
 
+// UTXOs lists the outputs the given address can spend in its next
+// transaction, for the wallet to pick inputs from.
+func (bcs *BlockchainServer) UTXOs(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		blockchainAddress := req.URL.Query().Get("blockchain_address")
+		outputs := bcs.GetBlockchain().SpendableOutputs(blockchainAddress)
+
+		m, _ := json.Marshal(&blockchain.UTXOResponse{Outputs: outputs})
+
+		w.Header().Add("Content-Type", "application/json")
+		io.WriteString(w, string(m[:]))
+
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, string(utils.JsonStatus("Invalid HTTP method")))
+	}
+}
+
+func (bcs *BlockchainServer) Reorgs(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		reorgs := bcs.GetBlockchain().Reorgs()
+
+		m, _ := json.Marshal(&blockchain.ReorgsResponse{Reorgs: reorgs})
+
+		w.Header().Add("Content-Type", "application/json")
+		io.WriteString(w, string(m[:]))
+
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, string(utils.JsonStatus("Invalid HTTP method")))
+	}
+}
+
 func (bcs *BlockchainServer) Amount(w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case http.MethodGet:
 
 		blockchainAdress := req.URL.Query().Get("blockchain_address")
-		amount := bcs.GetBlockchain().CalculateTotalAmount(blockchainAdress)
+		confirmed, pending := bcs.GetBlockchain().Balances(blockchainAdress)
 
-		ar := blockchain.AmountResponse{Amount: amount}
+		ar := blockchain.AmountResponse{Amount: confirmed, Confirmed: confirmed, Pending: pending}
 
 		m, _ := ar.MarshalJSON()
 
@@ -181,13 +338,267 @@ func (bcs *BlockchainServer) Amount(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// Nonce tells wallets which nonce the next transaction of an address must
+// carry.
+func (bcs *BlockchainServer) Nonce(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		blockchainAddress := req.URL.Query().Get("blockchain_address")
+		nonce := bcs.GetBlockchain().NextNonce(blockchainAddress)
+
+		nr := blockchain.NonceResponse{Nonce: nonce}
+		m, _ := json.Marshal(&nr)
+
+		w.Header().Add("Content-Type", "application/json")
+		io.WriteString(w, string(m[:]))
+
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, string(utils.JsonStatus("Invalid HTTP method")))
+	}
+}
+
+// TransactionProof returns the merkle inclusion proof of the transaction
+// whose hex ID is given in the id query parameter.
+func (bcs *BlockchainServer) TransactionProof(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		w.Header().Add("Content-Type", "application/json")
+
+		var id [32]byte
+		b, err := hex.DecodeString(req.URL.Query().Get("id"))
+		if err != nil || len(b) != len(id) {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonError("invalid transaction id", "invalid_id")))
+			return
+		}
+		copy(id[:], b)
+
+		pr, err := bcs.GetBlockchain().TransactionProof(id)
+		if errors.Is(err, blockchain.ErrUnknownTransaction) {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, string(utils.JsonError("transaction not found", "not_found")))
+			return
+		}
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			io.WriteString(w, string(utils.JsonError("fail", "internal_error")))
+			return
+		}
+
+		m, _ := json.Marshal(pr)
+		io.WriteString(w, string(m[:]))
+
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, string(utils.JsonStatus("Invalid HTTP method")))
+	}
+}
+
+// Block serves blocks of the block tree by hash on GET and takes blocks
+// announced by neighbors on POST.
+func (bcs *BlockchainServer) Block(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		w.Header().Add("Content-Type", "application/json")
+
+		var hash [32]byte
+		h, err := hex.DecodeString(req.URL.Query().Get("hash"))
+		if err != nil || len(h) != len(hash) {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonError("invalid block hash", "invalid_hash")))
+			return
+		}
+		copy(hash[:], h)
+
+		b, ok := bcs.GetBlockchain().BlockByHash(hash)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, string(utils.JsonError("block not found", "not_found")))
+			return
+		}
+
+		m, _ := json.Marshal(b)
+		io.WriteString(w, string(m[:]))
+
+	case http.MethodPost:
+		w.Header().Add("Content-Type", "application/json")
+
+		b := new(block.Block)
+		if err := json.NewDecoder(req.Body).Decode(b); err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonError("fail", "malformed_request")))
+			return
+		}
+
+		if _, err := bcs.GetBlockchain().ReceiveBlock(b); err != nil {
+			if !errors.Is(err, blockchain.ErrKnownBlock) {
+				log.Printf("ERROR: %v", err)
+			}
+			status, message, reason := blockErrorStatus(err)
+			w.WriteHeader(status)
+			io.WriteString(w, string(utils.JsonError(message, reason)))
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, string(utils.JsonStatus("success")))
+
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, string(utils.JsonStatus("Invalid HTTP method")))
+	}
+}
+
+// blockErrorStatus maps a ReceiveBlock error to a response status, message
+// and reason.
+func blockErrorStatus(err error) (int, string, string) {
+	switch {
+	case errors.Is(err, blockchain.ErrKnownBlock):
+		return http.StatusConflict, "block already known", "block_known"
+	case errors.Is(err, blockchain.ErrInvalidBlock):
+		return http.StatusUnprocessableEntity, "invalid block", "invalid_block"
+	case errors.Is(err, blockchain.ErrUnknownParent):
+		return http.StatusUnprocessableEntity, "parent block not found", "unknown_parent"
+	default:
+		return http.StatusInternalServerError, "fail", "internal_error"
+	}
+}
+
+// Headers serves main chain headers by height for headers-first sync:
+// count headers from height start, at most SYNC_HEADERS_PER_REQUEST.
+func (bcs *BlockchainServer) Headers(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		w.Header().Add("Content-Type", "application/json")
+
+		start, count, ok := heightRange(req, blockchain.SYNC_HEADERS_PER_REQUEST)
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonError("invalid height range", "invalid_range")))
+			return
+		}
+
+		headers := bcs.GetBlockchain().Headers(start, count)
+		m, _ := json.Marshal(&blockchain.HeadersResponse{Headers: headers})
+		io.WriteString(w, string(m[:]))
+
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, string(utils.JsonStatus("Invalid HTTP method")))
+	}
+}
+
+// Blocks serves main chain blocks by height, like Headers, at most
+// SYNC_BLOCKS_PER_REQUEST at once.
+func (bcs *BlockchainServer) Blocks(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		w.Header().Add("Content-Type", "application/json")
+
+		start, count, ok := heightRange(req, blockchain.SYNC_BLOCKS_PER_REQUEST)
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonError("invalid height range", "invalid_range")))
+			return
+		}
+
+		blocks := bcs.GetBlockchain().Blocks(start, count)
+		m, _ := json.Marshal(&blockchain.BlocksResponse{Blocks: blocks})
+		io.WriteString(w, string(m[:]))
+
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, string(utils.JsonStatus("Invalid HTTP method")))
+	}
+}
+
+// heightRange reads the start and count query parameters, capping count at
+// max. count defaults to max.
+func heightRange(req *http.Request, max int) (int, int, bool) {
+	start, err := strconv.Atoi(req.URL.Query().Get("start"))
+	if err != nil || start < 0 {
+		return 0, 0, false
+	}
+
+	count := max
+	if c := req.URL.Query().Get("count"); c != "" {
+		if count, err = strconv.Atoi(c); err != nil || count < 0 {
+			return 0, 0, false
+		}
+	}
+	if count > max {
+		count = max
+	}
+	return start, count, true
+}
+
+// Sync reports the progress of the headers-first sync on GET and starts a
+// new one on POST.
+func (bcs *BlockchainServer) Sync(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		w.Header().Add("Content-Type", "application/json")
+
+		progress := bcs.GetBlockchain().SyncProgress()
+		m, _ := json.Marshal(&progress)
+		io.WriteString(w, string(m[:]))
+
+	case http.MethodPost:
+		w.Header().Add("Content-Type", "application/json")
+
+		if err := bcs.GetBlockchain().StartSync(); err != nil {
+			w.WriteHeader(http.StatusConflict)
+			io.WriteString(w, string(utils.JsonError("sync already running", "sync_running")))
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+		io.WriteString(w, string(utils.JsonStatus("success")))
+
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, string(utils.JsonStatus("Invalid HTTP method")))
+	}
+}
+
+func (bcs *BlockchainServer) Consensus(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodPut:
+		bc := bcs.GetBlockchain()
+		replaced := bc.ResolveConflicts()
+
+		w.Header().Add("Content-Type", "application/json")
+		if replaced {
+			io.WriteString(w, string(utils.JsonStatus("success")))
+		} else {
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+		}
+
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, string(utils.JsonStatus("Invalid HTTP method")))
+	}
+}
+
 func (bcs *BlockchainServer) Start() {
 	bcs.GetBlockchain().Run()
 
 	http.HandleFunc("/", bcs.GetChain)
 	http.HandleFunc("/transaction", bcs.Transactions)
+	http.HandleFunc("/transaction/proof", bcs.TransactionProof)
+	http.HandleFunc("/block", bcs.Block)
+	http.HandleFunc("/blocks", bcs.Blocks)
+	http.HandleFunc("/headers", bcs.Headers)
+	http.HandleFunc("/sync", bcs.Sync)
 	http.HandleFunc("/mine", bcs.Mine)
 	http.HandleFunc("/mine/start", bcs.StartMine)
 	http.HandleFunc("/amount", bcs.Amount)
+	http.HandleFunc("/nonce", bcs.Nonce)
+	http.HandleFunc("/utxos", bcs.UTXOs)
+	http.HandleFunc("/consensus", bcs.Consensus)
+	http.HandleFunc("/reorgs", bcs.Reorgs)
 	log.Fatal(http.ListenAndServe("0.0.0.0:"+strconv.Itoa(int(bcs.Port())), nil))
 }
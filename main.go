@@ -4,6 +4,7 @@ import (
 	"flag"
 	"log"
 
+	"github.com/Ethical-Ralph/go-block/blockchain"
 	blockchainserver "github.com/Ethical-Ralph/go-block/blockchain_server"
 )
 
@@ -13,9 +14,16 @@ func init() {
 
 func main() {
 	port := flag.Uint("port", 8080, "port to listen on")
+	dataDir := flag.String("data-dir", "data", "directory for the chain and node files")
+	minerAddress := flag.String("miner-address", "", "address receiving mining rewards (defaults to the node's own wallet)")
+	maxBlockTransactions := flag.Int("block-max-txs", blockchain.BLOCK_MAX_TRANSACTIONS, "most transactions per mined block, coinbase included")
+	maxBlockBytes := flag.Int("block-max-bytes", blockchain.BLOCK_MAX_BYTES, "most transaction bytes per mined block, coinbase included")
+	targetInterval := flag.Duration("block-interval", blockchain.BLOCK_TARGET_INTERVAL, "block interval difficulty is retargeted towards; must match across nodes")
+	mineEmptyBlocks := flag.Bool("mine-empty", true, "mine reward-only blocks while the transaction pool is empty")
 	flag.Parse()
 
-	app := blockchainserver.NewBlockchainServer((uint16(*port)))
+	app := blockchainserver.NewBlockchainServer(uint16(*port), *dataDir, *minerAddress,
+		*maxBlockTransactions, *maxBlockBytes, *targetInterval, *mineEmptyBlocks)
 
 	app.Start()
 }
@@ -0,0 +1,314 @@
+package mempool
+
+import (
+	"container/heap"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Ethical-Ralph/go-block/transaction"
+)
+
+var (
+	ErrPoolFull  = errors.New("mempool full and fee rate too low to evict")
+	ErrDuplicate = errors.New("transaction already in mempool")
+)
+
+// entry is a pending transaction with what the pool needs to know about it
+// worked out once, on arrival. seq numbers entries in arrival order.
+type entry struct {
+	tx      *transaction.Transaction
+	id      [32]byte
+	size    int
+	feeRate float64
+	added   time.Time
+	seq     uint64
+}
+
+// entryHeap is a heap of entries ordered by less.
+type entryHeap struct {
+	entries []*entry
+	less    func(a *entry, b *entry) bool
+}
+
+func (h *entryHeap) Len() int           { return len(h.entries) }
+func (h *entryHeap) Less(i, j int) bool { return h.less(h.entries[i], h.entries[j]) }
+func (h *entryHeap) Swap(i, j int)      { h.entries[i], h.entries[j] = h.entries[j], h.entries[i] }
+func (h *entryHeap) Push(x interface{}) { h.entries = append(h.entries, x.(*entry)) }
+
+func (h *entryHeap) Pop() interface{} {
+	last := h.entries[len(h.entries)-1]
+	h.entries = h.entries[:len(h.entries)-1]
+	return last
+}
+
+// Mempool holds transactions waiting to be mined. It is safe for concurrent
+// use and indexes transactions by ID and by sender, each sender's in nonce
+// order. It does not verify transactions; the blockchain does that first.
+type Mempool struct {
+	maxCount int
+	maxBytes int
+	ttl      time.Duration
+
+	entries  map[[32]byte]*entry
+	bySender map[string][]*entry
+	arrivals []*entry
+	bytes    int
+	seq      uint64
+	mux      sync.Mutex
+}
+
+// NewMempool returns an empty mempool holding at most maxCount transactions
+// and maxBytes encoded bytes, each for at most ttl.
+func NewMempool(maxCount int, maxBytes int, ttl time.Duration) *Mempool {
+	return &Mempool{
+		maxCount: maxCount,
+		maxBytes: maxBytes,
+		ttl:      ttl,
+		entries:  make(map[[32]byte]*entry),
+		bySender: make(map[string][]*entry),
+	}
+}
+
+// Add puts t in the pool. When that breaks a limit, transactions with a
+// lower fee rate than t are evicted and returned. Only the last pending
+// transaction of a sender is ever evicted, so no sender is left with a nonce
+// gap, and never one t depends on. If not enough can be evicted, t is
+// rejected with ErrPoolFull and the pool is unchanged.
+func (mp *Mempool) Add(t *transaction.Transaction) ([]*transaction.Transaction, error) {
+	mp.mux.Lock()
+	defer mp.mux.Unlock()
+
+	size := t.Size()
+	e := &entry{tx: t, id: t.ID(), size: size, feeRate: float64(t.Fee) / float64(size), added: time.Now(), seq: mp.seq}
+	if _, ok := mp.entries[e.id]; ok {
+		return nil, ErrDuplicate
+	}
+
+	victims, ok := mp.victims(e)
+	if !ok {
+		return nil, ErrPoolFull
+	}
+
+	evicted := make([]*transaction.Transaction, 0, len(victims))
+	for _, v := range victims {
+		mp.remove(v)
+		evicted = append(evicted, v.tx)
+	}
+	mp.seq++
+	mp.insert(e)
+	return evicted, nil
+}
+
+// victims picks the entries to evict so that e fits, cheapest sender tail
+// first and the newest of equally cheap ones. The caller must hold mux.
+func (mp *Mempool) victims(e *entry) ([]*entry, bool) {
+	count, bytes := len(mp.entries)+1, mp.bytes+e.size
+	if e.size > mp.maxBytes {
+		return nil, false
+	}
+	if count <= mp.maxCount && bytes <= mp.maxBytes {
+		return nil, true
+	}
+
+	tails := &entryHeap{less: func(a *entry, b *entry) bool {
+		if a.feeRate != b.feeRate {
+			return a.feeRate < b.feeRate
+		}
+		return a.seq > b.seq
+	}}
+	for sender, queue := range mp.bySender {
+		if sender != e.tx.SenderBlockchainAddress {
+			tails.entries = append(tails.entries, queue[len(queue)-1])
+		}
+	}
+	heap.Init(tails)
+
+	evicted := make(map[string]int)
+	victims := make([]*entry, 0)
+	for count > mp.maxCount || bytes > mp.maxBytes {
+		if tails.Len() == 0 || tails.entries[0].feeRate >= e.feeRate {
+			return nil, false
+		}
+		cheapest := heap.Pop(tails).(*entry)
+
+		sender := cheapest.tx.SenderBlockchainAddress
+		evicted[sender]++
+		if queue := mp.bySender[sender]; evicted[sender] < len(queue) {
+			heap.Push(tails, queue[len(queue)-1-evicted[sender]])
+		}
+		victims = append(victims, cheapest)
+		count--
+		bytes -= cheapest.size
+	}
+	return victims, true
+}
+
+// insert indexes e. The caller must hold mux.
+func (mp *Mempool) insert(e *entry) {
+	sender := e.tx.SenderBlockchainAddress
+	queue := append(mp.bySender[sender], e)
+	sort.SliceStable(queue, func(i, j int) bool { return queue[i].tx.Nonce < queue[j].tx.Nonce })
+
+	mp.bySender[sender] = queue
+	mp.entries[e.id] = e
+	mp.arrivals = append(mp.arrivals, e)
+	mp.bytes += e.size
+}
+
+// remove drops e from every index. The caller must hold mux.
+func (mp *Mempool) remove(e *entry) {
+	sender := e.tx.SenderBlockchainAddress
+	queue := mp.bySender[sender]
+	for i, q := range queue {
+		if q == e {
+			queue = append(queue[:i:i], queue[i+1:]...)
+			break
+		}
+	}
+	if len(queue) == 0 {
+		delete(mp.bySender, sender)
+	} else {
+		mp.bySender[sender] = queue
+	}
+
+	for i, a := range mp.arrivals {
+		if a == e {
+			mp.arrivals = append(mp.arrivals[:i:i], mp.arrivals[i+1:]...)
+			break
+		}
+	}
+
+	delete(mp.entries, e.id)
+	mp.bytes -= e.size
+}
+
+// Remove drops the transactions with the given IDs, for example those a
+// newly accepted block included. Unknown IDs are ignored.
+func (mp *Mempool) Remove(ids ...[32]byte) {
+	mp.mux.Lock()
+	defer mp.mux.Unlock()
+
+	for _, id := range ids {
+		if e, ok := mp.entries[id]; ok {
+			mp.remove(e)
+		}
+	}
+}
+
+// RemoveIncluded drops every transaction of transactions that is in the
+// pool and leaves the rest pending.
+func (mp *Mempool) RemoveIncluded(transactions []*transaction.Transaction) {
+	ids := make([][32]byte, 0, len(transactions))
+	for _, t := range transactions {
+		ids = append(ids, t.ID())
+	}
+	mp.Remove(ids...)
+}
+
+// Expire drops transactions older than the TTL together with the later
+// transactions of the same sender, which cannot be mined without them.
+func (mp *Mempool) Expire(now time.Time) []*transaction.Transaction {
+	mp.mux.Lock()
+	defer mp.mux.Unlock()
+
+	expired := make([]*transaction.Transaction, 0)
+	for _, queue := range mp.bySender {
+		for i, e := range queue {
+			if now.Sub(e.added) <= mp.ttl {
+				continue
+			}
+			stale := append([]*entry{}, queue[i:]...)
+			for _, s := range stale {
+				mp.remove(s)
+				expired = append(expired, s.tx)
+			}
+			break
+		}
+	}
+	return expired
+}
+
+// Has reports whether the transaction with id is pending.
+func (mp *Mempool) Has(id [32]byte) bool {
+	mp.mux.Lock()
+	defer mp.mux.Unlock()
+
+	_, ok := mp.entries[id]
+	return ok
+}
+
+// BySender returns the pending transactions of sender in nonce order.
+func (mp *Mempool) BySender(sender string) []*transaction.Transaction {
+	mp.mux.Lock()
+	defer mp.mux.Unlock()
+
+	return transactionsOf(mp.bySender[sender])
+}
+
+// Transactions returns every pending transaction in arrival order.
+func (mp *Mempool) Transactions() []*transaction.Transaction {
+	mp.mux.Lock()
+	defer mp.mux.Unlock()
+
+	return transactionsOf(mp.arrivals)
+}
+
+// ByFeeRate returns every pending transaction, highest fee rate first, while
+// keeping each sender's transactions in nonce order: a sender's next
+// transaction only competes once the ones before it are placed. Equal rates
+// keep arrival order.
+func (mp *Mempool) ByFeeRate() []*transaction.Transaction {
+	mp.mux.Lock()
+	defer mp.mux.Unlock()
+
+	heads := &entryHeap{less: func(a *entry, b *entry) bool {
+		if a.feeRate != b.feeRate {
+			return a.feeRate > b.feeRate
+		}
+		return a.seq < b.seq
+	}}
+	for _, queue := range mp.bySender {
+		heads.entries = append(heads.entries, queue[0])
+	}
+	heap.Init(heads)
+
+	placed := make(map[string]int, len(mp.bySender))
+	ordered := make([]*transaction.Transaction, 0, len(mp.entries))
+	for heads.Len() > 0 {
+		best := heap.Pop(heads).(*entry)
+		ordered = append(ordered, best.tx)
+
+		sender := best.tx.SenderBlockchainAddress
+		placed[sender]++
+		if queue := mp.bySender[sender]; placed[sender] < len(queue) {
+			heap.Push(heads, queue[placed[sender]])
+		}
+	}
+	return ordered
+}
+
+// Len is the number of pending transactions.
+func (mp *Mempool) Len() int {
+	mp.mux.Lock()
+	defer mp.mux.Unlock()
+
+	return len(mp.entries)
+}
+
+// Bytes is the encoded size of every pending transaction together.
+func (mp *Mempool) Bytes() int {
+	mp.mux.Lock()
+	defer mp.mux.Unlock()
+
+	return mp.bytes
+}
+
+func transactionsOf(entries []*entry) []*transaction.Transaction {
+	transactions := make([]*transaction.Transaction, 0, len(entries))
+	for _, e := range entries {
+		transactions = append(transactions, e.tx)
+	}
+	return transactions
+}
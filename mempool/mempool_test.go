@@ -0,0 +1,196 @@
+package mempool
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Ethical-Ralph/go-block/amount"
+	"github.com/Ethical-Ralph/go-block/transaction"
+)
+
+// tx is a transaction of sender with the given nonce paying fee coins.
+// Fees differ by far more than sizes do, so fee order is fee rate order.
+func tx(sender string, nonce uint64, fee int) *transaction.Transaction {
+	return transaction.NewTransaction(sender, "recipient", amount.COIN, amount.Amount(fee)*amount.COIN, nonce, 1)
+}
+
+// names lists transactions as sender and nonce, e.g. "A0".
+func names(transactions []*transaction.Transaction) []string {
+	n := make([]string, 0, len(transactions))
+	for _, t := range transactions {
+		n = append(n, t.SenderBlockchainAddress+string(rune('0'+t.Nonce)))
+	}
+	return n
+}
+
+func add(t *testing.T, mp *Mempool, transactions ...*transaction.Transaction) {
+	t.Helper()
+	for _, tr := range transactions {
+		if _, err := mp.Add(tr); err != nil {
+			t.Fatalf("Add(%s): %v", names([]*transaction.Transaction{tr})[0], err)
+		}
+	}
+}
+
+func TestAddDuplicate(t *testing.T) {
+	mp := NewMempool(10, 1<<20, time.Hour)
+	add(t, mp, tx("A", 0, 1))
+	if _, err := mp.Add(tx("A", 0, 1)); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("Add of a pending transaction = %v, want %v", err, ErrDuplicate)
+	}
+}
+
+func TestByFeeRate(t *testing.T) {
+	mp := NewMempool(10, 1<<20, time.Hour)
+	// A's second transaction pays the most but cannot go before its first,
+	// which arrives after it. B and C pay the same and keep arrival order.
+	add(t, mp, tx("A", 1, 100), tx("B", 0, 50), tx("A", 0, 1), tx("C", 0, 50), tx("C", 1, 20))
+
+	want := []string{"B0", "C0", "C1", "A0", "A1"}
+	if got := names(mp.ByFeeRate()); !reflect.DeepEqual(got, want) {
+		t.Errorf("ByFeeRate = %v, want %v", got, want)
+	}
+	if got := names(mp.BySender("A")); !reflect.DeepEqual(got, []string{"A0", "A1"}) {
+		t.Errorf("BySender(A) = %v, want nonce order", got)
+	}
+	if got := names(mp.Transactions()); !reflect.DeepEqual(got, []string{"A1", "B0", "A0", "C0", "C1"}) {
+		t.Errorf("Transactions = %v, want arrival order", got)
+	}
+}
+
+func TestEvictCount(t *testing.T) {
+	mp := NewMempool(3, 1<<20, time.Hour)
+	// A0 is the cheapest, but evicting it would leave A1 behind a gap.
+	add(t, mp, tx("A", 0, 1), tx("A", 1, 30), tx("B", 0, 5))
+
+	evicted, err := mp.Add(tx("C", 0, 20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := names(evicted); !reflect.DeepEqual(got, []string{"B0"}) {
+		t.Errorf("evicted %v, want [B0]", got)
+	}
+
+	// Nothing cheaper than D0 can go.
+	if _, err := mp.Add(tx("D", 0, 2)); !errors.Is(err, ErrPoolFull) {
+		t.Errorf("Add of the cheapest = %v, want %v", err, ErrPoolFull)
+	}
+
+	// A2 builds on A1, so none of A's transactions can make room for it.
+	evicted, err = mp.Add(tx("A", 2, 100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := names(evicted); !reflect.DeepEqual(got, []string{"C0"}) {
+		t.Errorf("evicted %v, want [C0]", got)
+	}
+	if got := names(mp.BySender("A")); !reflect.DeepEqual(got, []string{"A0", "A1", "A2"}) {
+		t.Errorf("BySender(A) = %v", got)
+	}
+	if mp.Len() != 3 {
+		t.Errorf("Len = %d, want 3", mp.Len())
+	}
+}
+
+func TestEvictSenderTails(t *testing.T) {
+	mp := NewMempool(4, 1<<20, time.Hour)
+	add(t, mp, tx("A", 0, 3), tx("A", 1, 2), tx("A", 2, 1), tx("B", 0, 50))
+
+	// Each eviction takes the last of A's transactions, never one before it.
+	for _, want := range []string{"A2", "A1"} {
+		evicted, err := mp.Add(tx("C", uint64(len(mp.BySender("C"))), 40))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := names(evicted); !reflect.DeepEqual(got, []string{want}) {
+			t.Errorf("evicted %v, want [%s]", got, want)
+		}
+	}
+	if got := names(mp.BySender("A")); !reflect.DeepEqual(got, []string{"A0"}) {
+		t.Errorf("BySender(A) = %v, want [A0]", got)
+	}
+}
+
+func TestEvictBytes(t *testing.T) {
+	small := tx("A", 0, 1)
+	mp := NewMempool(100, 2*small.Size()+small.Size()/2, time.Hour)
+	add(t, mp, small, tx("B", 0, 2))
+
+	evicted, err := mp.Add(tx("C", 0, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := names(evicted); !reflect.DeepEqual(got, []string{"A0"}) {
+		t.Errorf("evicted %v, want [A0]", got)
+	}
+	if mp.Bytes() > 2*small.Size()+small.Size()/2 {
+		t.Errorf("Bytes = %d over the limit", mp.Bytes())
+	}
+
+	huge := NewMempool(100, small.Size()-1, time.Hour)
+	if _, err := huge.Add(small); !errors.Is(err, ErrPoolFull) {
+		t.Errorf("Add of a transaction over the byte limit = %v, want %v", err, ErrPoolFull)
+	}
+}
+
+func TestEvictFailureKeepsPool(t *testing.T) {
+	a0, b0 := tx("A", 0, 10), tx("B", 0, 1)
+	size := a0.Size()
+	mp := NewMempool(100, 2*size+size/2, time.Hour)
+	add(t, mp, a0, b0)
+
+	// C0 is about twice as big, so both A0 and B0 would have to go. It
+	// outbids B0 but not A0, so it is rejected and B0 stays as well.
+	c0 := transaction.NewTransaction("C", strings.Repeat("r", size), amount.COIN, 10*amount.COIN, 0, 1)
+	if c0.Size() <= size+size/2 || c0.Size() > 2*size+size/2 {
+		t.Fatalf("C0 is %d bytes, want it to need both slots of %d", c0.Size(), size)
+	}
+	if _, err := mp.Add(c0); !errors.Is(err, ErrPoolFull) {
+		t.Errorf("Add = %v, want %v", err, ErrPoolFull)
+	}
+	if got := names(mp.Transactions()); !reflect.DeepEqual(got, []string{"A0", "B0"}) {
+		t.Errorf("Transactions = %v, want [A0 B0]", got)
+	}
+}
+
+func TestExpire(t *testing.T) {
+	mp := NewMempool(10, 1<<20, time.Hour)
+	add(t, mp, tx("A", 0, 1), tx("A", 1, 1), tx("A", 2, 1), tx("B", 0, 1))
+
+	// A1 is stale; A2 cannot be mined without it, A0 and B0 stay.
+	stale := mp.entries[tx("A", 1, 1).ID()]
+	stale.added = stale.added.Add(-2 * time.Hour)
+	bytes := mp.Bytes()
+
+	expired := mp.Expire(time.Now())
+	if got := names(expired); !reflect.DeepEqual(got, []string{"A1", "A2"}) {
+		t.Fatalf("expired %v, want [A1 A2]", got)
+	}
+	if got := names(mp.Transactions()); !reflect.DeepEqual(got, []string{"A0", "B0"}) {
+		t.Errorf("Transactions = %v, want [A0 B0]", got)
+	}
+	if want := bytes - expired[0].Size() - expired[1].Size(); mp.Bytes() != want {
+		t.Errorf("Bytes = %d, want %d", mp.Bytes(), want)
+	}
+
+	if got := mp.Expire(time.Now().Add(2 * time.Hour)); len(got) != 2 || mp.Len() != 0 {
+		t.Errorf("expired %v, %d left, want everything", names(got), mp.Len())
+	}
+}
+
+func TestRemoveIncluded(t *testing.T) {
+	mp := NewMempool(10, 1<<20, time.Hour)
+	a0, a1, b0 := tx("A", 0, 1), tx("A", 1, 1), tx("B", 0, 1)
+	add(t, mp, a0, a1, b0)
+
+	mp.RemoveIncluded([]*transaction.Transaction{a0, b0, tx("C", 0, 1)})
+	if got := names(mp.Transactions()); !reflect.DeepEqual(got, []string{"A1"}) {
+		t.Errorf("Transactions = %v, want [A1]", got)
+	}
+	if mp.Has(a0.ID()) || !mp.Has(a1.ID()) || mp.Bytes() != a1.Size() {
+		t.Errorf("indexes out of step after RemoveIncluded")
+	}
+}
@@ -0,0 +1,161 @@
+package merkle
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Leaves and inner nodes are hashed with different prefixes, as in
+// RFC 6962, so an inner node can never pass for a leaf. A node without a
+// sibling moves up a level unchanged instead of being paired with itself.
+const (
+	leafPrefix = 0x00
+	nodePrefix = 0x01
+)
+
+var ErrIndexOutOfRange = errors.New("leaf index out of range")
+
+func hashLeaf(leaf [32]byte) [32]byte {
+	return sha256.Sum256(append([]byte{leafPrefix}, leaf[:]...))
+}
+
+func hashNode(left [32]byte, right [32]byte) [32]byte {
+	buf := make([]byte, 0, 65)
+	buf = append(buf, nodePrefix)
+	buf = append(buf, left[:]...)
+	buf = append(buf, right[:]...)
+	return sha256.Sum256(buf)
+}
+
+// Root is the merkle root over leaves, in order. The root of no leaves is
+// the SHA-256 of the empty string.
+func Root(leaves [][32]byte) [32]byte {
+	if len(leaves) == 0 {
+		return sha256.Sum256(nil)
+	}
+
+	level := make([][32]byte, len(leaves))
+	for i, l := range leaves {
+		level[i] = hashLeaf(l)
+	}
+	for len(level) > 1 {
+		level = nextLevel(level)
+	}
+	return level[0]
+}
+
+func nextLevel(level [][32]byte) [][32]byte {
+	next := make([][32]byte, 0, (len(level)+1)/2)
+	for i := 0; i < len(level); i += 2 {
+		if i+1 == len(level) {
+			next = append(next, level[i])
+			continue
+		}
+		next = append(next, hashNode(level[i], level[i+1]))
+	}
+	return next
+}
+
+// Proof shows that a leaf is at Index among Total leaves under a root. It
+// holds the sibling hashes from the leaf level up.
+type Proof struct {
+	Index    int
+	Total    int
+	Siblings [][32]byte
+}
+
+// NewProof builds the inclusion proof for the leaf at index.
+func NewProof(leaves [][32]byte, index int) (*Proof, error) {
+	if index < 0 || index >= len(leaves) {
+		return nil, fmt.Errorf("%w: %d of %d", ErrIndexOutOfRange, index, len(leaves))
+	}
+
+	level := make([][32]byte, len(leaves))
+	for i, l := range leaves {
+		level[i] = hashLeaf(l)
+	}
+
+	p := &Proof{Index: index, Total: len(leaves), Siblings: make([][32]byte, 0)}
+	for i := index; len(level) > 1; i /= 2 {
+		if i%2 == 1 {
+			p.Siblings = append(p.Siblings, level[i-1])
+		} else if i+1 < len(level) {
+			p.Siblings = append(p.Siblings, level[i+1])
+		}
+		level = nextLevel(level)
+	}
+	return p, nil
+}
+
+// Verify reports whether leaf is included under root according to p.
+func (p *Proof) Verify(leaf [32]byte, root [32]byte) bool {
+	if p.Index < 0 || p.Index >= p.Total {
+		return false
+	}
+
+	h := hashLeaf(leaf)
+	used := 0
+	for i, n := p.Index, p.Total; n > 1; i, n = i/2, (n+1)/2 {
+		if i%2 == 0 && i+1 == n {
+			continue
+		}
+		if used == len(p.Siblings) {
+			return false
+		}
+		if i%2 == 1 {
+			h = hashNode(p.Siblings[used], h)
+		} else {
+			h = hashNode(h, p.Siblings[used])
+		}
+		used++
+	}
+	return used == len(p.Siblings) && h == root
+}
+
+func (p *Proof) MarshalJSON() ([]byte, error) {
+	siblings := make([]string, len(p.Siblings))
+	for i, s := range p.Siblings {
+		siblings[i] = fmt.Sprintf("%x", s)
+	}
+	return json.Marshal(struct {
+		Index    int      `json:"index"`
+		Total    int      `json:"total"`
+		Siblings []string `json:"siblings"`
+	}{
+		Index:    p.Index,
+		Total:    p.Total,
+		Siblings: siblings,
+	})
+}
+
+func (p *Proof) UnmarshalJSON(data []byte) error {
+	var siblings []string
+	v := &struct {
+		Index    *int      `json:"index"`
+		Total    *int      `json:"total"`
+		Siblings *[]string `json:"siblings"`
+	}{
+		Index:    &p.Index,
+		Total:    &p.Total,
+		Siblings: &siblings,
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return err
+	}
+
+	p.Siblings = make([][32]byte, len(siblings))
+	for i, s := range siblings {
+		b, err := hex.DecodeString(s)
+		if err != nil {
+			return err
+		}
+		if len(b) != 32 {
+			return fmt.Errorf("invalid sibling length %d", len(b))
+		}
+		copy(p.Siblings[i][:], b)
+	}
+	return nil
+}
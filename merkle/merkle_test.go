@@ -0,0 +1,137 @@
+package merkle
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func testLeaves(n int) [][32]byte {
+	leaves := make([][32]byte, n)
+	for i := range leaves {
+		leaves[i] = sha256.Sum256([]byte{byte(i)})
+	}
+	return leaves
+}
+
+func TestRoot(t *testing.T) {
+	if got := Root(nil); got != sha256.Sum256(nil) {
+		t.Errorf("Root of no leaves = %x", got)
+	}
+
+	l := testLeaves(5)
+	h := make([][32]byte, len(l))
+	for i := range l {
+		h[i] = hashLeaf(l[i])
+	}
+	// The fifth leaf has no sibling on any level and moves up unchanged
+	// until the top.
+	tests := []struct {
+		n    int
+		want [32]byte
+	}{
+		{1, h[0]},
+		{2, hashNode(h[0], h[1])},
+		{3, hashNode(hashNode(h[0], h[1]), h[2])},
+		{4, hashNode(hashNode(h[0], h[1]), hashNode(h[2], h[3]))},
+		{5, hashNode(hashNode(hashNode(h[0], h[1]), hashNode(h[2], h[3])), h[4])},
+	}
+	for _, tt := range tests {
+		if got := Root(l[:tt.n]); got != tt.want {
+			t.Errorf("Root of %d leaves = %x, want %x", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestProofs(t *testing.T) {
+	for n := 1; n <= 9; n++ {
+		leaves := testLeaves(n)
+		root := Root(leaves)
+		for i := 0; i < n; i++ {
+			p, err := NewProof(leaves, i)
+			if err != nil {
+				t.Fatalf("NewProof(%d of %d): %v", i, n, err)
+			}
+			if !p.Verify(leaves[i], root) {
+				t.Errorf("proof of %d of %d does not verify", i, n)
+			}
+
+			other := leaves[(i+1)%n]
+			if n > 1 && p.Verify(other, root) {
+				t.Errorf("proof of %d of %d verifies a wrong leaf", i, n)
+			}
+			if p.Verify(leaves[i], sha256.Sum256([]byte("root"))) {
+				t.Errorf("proof of %d of %d verifies against a wrong root", i, n)
+			}
+			if len(p.Siblings) > 0 {
+				truncated := &Proof{Index: p.Index, Total: p.Total, Siblings: p.Siblings[:len(p.Siblings)-1]}
+				if truncated.Verify(leaves[i], root) {
+					t.Errorf("proof of %d of %d verifies with a sibling missing", i, n)
+				}
+			}
+			extended := &Proof{Index: p.Index, Total: p.Total, Siblings: append(append([][32]byte{}, p.Siblings...), root)}
+			if extended.Verify(leaves[i], root) {
+				t.Errorf("proof of %d of %d verifies with an extra sibling", i, n)
+			}
+			if n > 1 {
+				moved := &Proof{Index: (i + 1) % n, Total: p.Total, Siblings: p.Siblings}
+				if moved.Verify(leaves[i], root) {
+					t.Errorf("proof of %d of %d verifies at index %d", i, n, moved.Index)
+				}
+			}
+		}
+	}
+}
+
+func TestProofOddLeaf(t *testing.T) {
+	// With 5 leaves the last one only meets a sibling at the top.
+	leaves := testLeaves(5)
+	p, err := NewProof(leaves, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Siblings) != 1 {
+		t.Fatalf("proof of the odd leaf has %d siblings, want 1", len(p.Siblings))
+	}
+	if want := Root(leaves[:4]); p.Siblings[0] != want {
+		t.Errorf("sibling %x, want the root of the first 4 leaves %x", p.Siblings[0], want)
+	}
+}
+
+func TestNewProofOutOfRange(t *testing.T) {
+	leaves := testLeaves(3)
+	for _, i := range []int{-1, 3} {
+		if _, err := NewProof(leaves, i); !errors.Is(err, ErrIndexOutOfRange) {
+			t.Errorf("NewProof(%d of 3) = %v, want %v", i, err, ErrIndexOutOfRange)
+		}
+	}
+	bad := &Proof{Index: 3, Total: 3}
+	if bad.Verify(leaves[0], Root(leaves)) {
+		t.Error("proof with an index past the total verifies")
+	}
+}
+
+func TestProofJSON(t *testing.T) {
+	leaves := testLeaves(7)
+	p, err := NewProof(leaves, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded := new(Proof)
+	if err := json.Unmarshal(m, decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.Verify(leaves[5], Root(leaves)) {
+		t.Errorf("decoded proof %s does not verify", m)
+	}
+
+	if err := json.Unmarshal([]byte(`{"index":0,"total":2,"siblings":["00"]}`), new(Proof)); err == nil {
+		t.Error("short sibling accepted")
+	}
+}
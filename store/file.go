@@ -0,0 +1,167 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/Ethical-Ralph/go-block/block"
+)
+
+// FileStore is an append-only log with one JSON encoded block per line.
+// Blocks are also kept in memory, so reads never touch the file.
+type FileStore struct {
+	file    *os.File
+	index   *index
+	offsets []int64
+	size    int64
+	mux     sync.RWMutex
+}
+
+// OpenFileStore opens or creates the log at path and loads every block in
+// it. A partially written last line, left behind by a crash mid-append, is
+// cut off.
+func OpenFileStore(path string) (*FileStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	fs := &FileStore{file: f, index: newIndex()}
+	if err := fs.load(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return fs, nil
+}
+
+func (fs *FileStore) load() error {
+	r := bufio.NewReader(fs.file)
+	var offset int64 = 0
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		b := new(block.Block)
+		if err := json.Unmarshal(line, b); err != nil {
+			return fmt.Errorf("block %d at offset %d: %v", len(fs.offsets), offset, err)
+		}
+
+		fs.index.append(b)
+		fs.offsets = append(fs.offsets, offset)
+		offset += int64(len(line))
+	}
+
+	fs.size = offset
+	if err := fs.file.Truncate(fs.size); err != nil {
+		return err
+	}
+	_, err := fs.file.Seek(fs.size, io.SeekStart)
+	return err
+}
+
+// AppendBlock writes b at the end of the log. The block is written at the
+// end of the last one stored, whatever the file offset, and a failed write
+// is cut off again, so the next append does not land after a partial line.
+func (fs *FileStore) AppendBlock(b *block.Block) error {
+	m, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	m = append(m, '\n')
+
+	fs.mux.Lock()
+	defer fs.mux.Unlock()
+
+	if _, err := fs.file.WriteAt(m, fs.size); err != nil {
+		return fs.discard(err)
+	}
+	if err := fs.file.Sync(); err != nil {
+		return fs.discard(err)
+	}
+
+	fs.index.append(b)
+	fs.offsets = append(fs.offsets, fs.size)
+	fs.size += int64(len(m))
+	return nil
+}
+
+// discard cuts the file back to the blocks stored after a failed append
+// and returns err. The caller must hold mux.
+func (fs *FileStore) discard(err error) error {
+	if terr := fs.file.Truncate(fs.size); terr != nil {
+		return fmt.Errorf("%v; cutting the log back to %d bytes: %v", err, fs.size, terr)
+	}
+	if _, serr := fs.file.Seek(fs.size, io.SeekStart); serr != nil {
+		return fmt.Errorf("%v; seeking to %d: %v", err, fs.size, serr)
+	}
+	return err
+}
+
+func (fs *FileStore) BlockByHeight(height int) (*block.Block, error) {
+	fs.mux.RLock()
+	defer fs.mux.RUnlock()
+
+	return fs.index.blockByHeight(height)
+}
+
+func (fs *FileStore) BlockByHash(hash [32]byte) (*block.Block, error) {
+	fs.mux.RLock()
+	defer fs.mux.RUnlock()
+
+	return fs.index.blockByHash(hash)
+}
+
+func (fs *FileStore) Blocks() ([]*block.Block, error) {
+	fs.mux.RLock()
+	defer fs.mux.RUnlock()
+
+	return fs.index.all(), nil
+}
+
+func (fs *FileStore) Truncate(height int) error {
+	fs.mux.Lock()
+	defer fs.mux.Unlock()
+
+	if height < 0 {
+		height = 0
+	}
+	if height >= len(fs.offsets) {
+		return nil
+	}
+
+	size := fs.offsets[height]
+	if err := fs.file.Truncate(size); err != nil {
+		return err
+	}
+
+	// The blocks are gone from the file even if syncing fails, so the index
+	// follows it and the next append goes where they were.
+	fs.index.truncate(height)
+	fs.offsets = fs.offsets[:height]
+	fs.size = size
+	if _, err := fs.file.Seek(size, io.SeekStart); err != nil {
+		return err
+	}
+	return fs.file.Sync()
+}
+
+func (fs *FileStore) Close() error {
+	fs.mux.Lock()
+	defer fs.mux.Unlock()
+
+	return fs.file.Close()
+}
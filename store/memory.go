@@ -0,0 +1,58 @@
+package store
+
+import (
+	"sync"
+
+	"github.com/Ethical-Ralph/go-block/block"
+)
+
+// MemoryStore keeps blocks only for the lifetime of the process.
+type MemoryStore struct {
+	index *index
+	mux   sync.RWMutex
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{index: newIndex()}
+}
+
+func (ms *MemoryStore) AppendBlock(b *block.Block) error {
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+
+	ms.index.append(b)
+	return nil
+}
+
+func (ms *MemoryStore) BlockByHeight(height int) (*block.Block, error) {
+	ms.mux.RLock()
+	defer ms.mux.RUnlock()
+
+	return ms.index.blockByHeight(height)
+}
+
+func (ms *MemoryStore) BlockByHash(hash [32]byte) (*block.Block, error) {
+	ms.mux.RLock()
+	defer ms.mux.RUnlock()
+
+	return ms.index.blockByHash(hash)
+}
+
+func (ms *MemoryStore) Blocks() ([]*block.Block, error) {
+	ms.mux.RLock()
+	defer ms.mux.RUnlock()
+
+	return ms.index.all(), nil
+}
+
+func (ms *MemoryStore) Truncate(height int) error {
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+
+	ms.index.truncate(height)
+	return nil
+}
+
+func (ms *MemoryStore) Close() error {
+	return nil
+}
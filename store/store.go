@@ -0,0 +1,72 @@
+package store
+
+import (
+	"errors"
+
+	"github.com/Ethical-Ralph/go-block/block"
+)
+
+var ErrNotFound = errors.New("block not found")
+
+// Store persists the blocks of a chain in height order. Height 0 is the
+// genesis block.
+type Store interface {
+	AppendBlock(b *block.Block) error
+	BlockByHeight(height int) (*block.Block, error)
+	BlockByHash(hash [32]byte) (*block.Block, error)
+	Blocks() ([]*block.Block, error)
+	// Truncate drops every block at or above height, so a replaced chain can
+	// be appended from the point where it diverges.
+	Truncate(height int) error
+	Close() error
+}
+
+// index keeps blocks in memory together with a hash lookup. It backs both
+// MemoryStore and the read side of FileStore.
+type index struct {
+	blocks   []*block.Block
+	byHashes map[[32]byte]int
+}
+
+func newIndex() *index {
+	return &index{byHashes: make(map[[32]byte]int)}
+}
+
+func (i *index) append(b *block.Block) {
+	i.byHashes[b.CalculateHash()] = len(i.blocks)
+	i.blocks = append(i.blocks, b)
+}
+
+func (i *index) blockByHeight(height int) (*block.Block, error) {
+	if height < 0 || height >= len(i.blocks) {
+		return nil, ErrNotFound
+	}
+	return i.blocks[height], nil
+}
+
+func (i *index) blockByHash(hash [32]byte) (*block.Block, error) {
+	height, ok := i.byHashes[hash]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return i.blocks[height], nil
+}
+
+func (i *index) all() []*block.Block {
+	blocks := make([]*block.Block, len(i.blocks))
+	copy(blocks, i.blocks)
+	return blocks
+}
+
+func (i *index) truncate(height int) {
+	if height < 0 {
+		height = 0
+	}
+	if height >= len(i.blocks) {
+		return
+	}
+	for _, b := range i.blocks[height:] {
+		delete(i.byHashes, b.CalculateHash())
+	}
+	i.blocks = i.blocks[:height]
+}
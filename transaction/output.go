@@ -0,0 +1,87 @@
+package transaction
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/Ethical-Ralph/go-block/amount"
+)
+
+// OutPoint names an output: the ID of the transaction that created it and
+// its position among that transaction's outputs.
+type OutPoint struct {
+	TxID  [32]byte
+	Index uint32
+}
+
+// Less orders outpoints by transaction ID, then index.
+func (op OutPoint) Less(other OutPoint) bool {
+	if c := bytes.Compare(op.TxID[:], other.TxID[:]); c != 0 {
+		return c < 0
+	}
+	return op.Index < other.Index
+}
+
+func (op OutPoint) String() string {
+	return fmt.Sprintf("%x:%d", op.TxID, op.Index)
+}
+
+func (op OutPoint) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		TxID  string `json:"txId"`
+		Index uint32 `json:"index"`
+	}{
+		TxID:  fmt.Sprintf("%x", op.TxID),
+		Index: op.Index,
+	})
+}
+
+func (op *OutPoint) UnmarshalJSON(data []byte) error {
+	var txID string
+	v := &struct {
+		TxID  *string `json:"txId"`
+		Index *uint32 `json:"index"`
+	}{
+		TxID:  &txID,
+		Index: &op.Index,
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return err
+	}
+
+	b, err := hex.DecodeString(txID)
+	if err != nil {
+		return err
+	}
+	if len(b) != len(op.TxID) {
+		return fmt.Errorf("invalid txId length %d", len(b))
+	}
+	copy(op.TxID[:], b)
+	return nil
+}
+
+// Output is value spendable by the owner of Address.
+type Output struct {
+	Address string        `json:"address"`
+	Value   amount.Amount `json:"value"`
+}
+
+const (
+	// Outputs of a transaction are always at these indexes; the change
+	// output only exists when Change is not zero.
+	OUTPUT_RECIPIENT = 0
+	OUTPUT_CHANGE    = 1
+)
+
+// Outputs are what the transaction creates: Value for the recipient and,
+// if any, Change back to the sender. The sender signs both amounts, so the
+// outputs are covered by the signature too.
+func (t *Transaction) Outputs() []Output {
+	outputs := []Output{{Address: t.RecipientBlockchainAddress, Value: t.Value}}
+	if t.Change != 0 {
+		outputs = append(outputs, Output{Address: t.SenderBlockchainAddress, Value: t.Change})
+	}
+	return outputs
+}
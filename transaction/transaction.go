@@ -1,36 +1,174 @@
 package transaction
 
 import (
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"strings"
+
+	"github.com/Ethical-Ralph/go-block/amount"
 )
 
 type Transaction struct {
 	SenderBlockchainAddress    string
 	RecipientBlockchainAddress string
-	Value                      float32
+	Value                      amount.Amount
+	// Fee goes to the miner of the block that includes the transaction and
+	// decides its priority there. It is optional and covered by the signature.
+	Fee amount.Amount
+	// Inputs are the sender's unspent outputs this transaction spends. They
+	// must add up to Value plus Change plus Fee exactly.
+	Inputs []OutPoint
+	Change amount.Amount
+	// Nonce counts the sender's transactions from 0, so every transaction of
+	// a sender has its own slot and a replayed one is rejected.
+	Nonce     uint64
+	Timestamp int64
+
+	// SenderPublicKey and Signature are hex strings as produced by
+	// utils.PublicKeyToString and utils.Signature.String. They are kept in
+	// blocks so anyone can verify the chain again; the coinbase has neither.
+	SenderPublicKey string
+	Signature       string
 }
 
-func NewTransaction(sender string, recipient string, value float32) *Transaction {
-	return &Transaction{sender, recipient, value}
+func NewTransaction(sender string, recipient string, value amount.Amount, fee amount.Amount,
+	nonce uint64, timestamp int64) *Transaction {
+	return &Transaction{
+		SenderBlockchainAddress:    sender,
+		RecipientBlockchainAddress: recipient,
+		Value:                      value,
+		Fee:                        fee,
+		Nonce:                      nonce,
+		Timestamp:                  timestamp,
+	}
+}
+
+// SigningPayload is the canonical encoding of everything the sender signs,
+// that is the transaction without its public key and signature. A zero fee
+// or change and empty inputs are left out, as for the coinbase.
+func (t *Transaction) SigningPayload() ([]byte, error) {
+	return json.Marshal(struct {
+		SenderBlockchainAddress    string        `json:"senderBlockchainAddress"`
+		RecipientBlockchainAddress string        `json:"recipientBlockchainAddress"`
+		Value                      amount.Amount `json:"value"`
+		Fee                        amount.Amount `json:"fee,omitempty"`
+		Change                     amount.Amount `json:"change,omitempty"`
+		Inputs                     []OutPoint    `json:"inputs,omitempty"`
+		Nonce                      uint64        `json:"nonce"`
+		Timestamp                  int64         `json:"timestamp"`
+	}{
+		SenderBlockchainAddress:    t.SenderBlockchainAddress,
+		RecipientBlockchainAddress: t.RecipientBlockchainAddress,
+		Value:                      t.Value,
+		Fee:                        t.Fee,
+		Change:                     t.Change,
+		Inputs:                     t.Inputs,
+		Nonce:                      t.Nonce,
+		Timestamp:                  t.Timestamp,
+	})
+}
+
+// ID is the SHA-256 of the signing payload. The signature is left out so
+// re-encoding a signature cannot change the ID.
+func (t *Transaction) ID() [32]byte {
+	m, _ := t.SigningPayload()
+	return sha256.Sum256(m)
+}
+
+// Hash is the SHA-256 of the full encoding, public key and signature
+// included. Block headers commit to it rather than the ID, so a block cannot
+// be passed on with its signatures altered and its hash unchanged.
+func (t *Transaction) Hash() [32]byte {
+	m, _ := t.MarshalJSON()
+	return sha256.Sum256(m)
+}
+
+func (t *Transaction) IDString() string {
+	return fmt.Sprintf("%x", t.ID())
+}
+
+// Cost is what the inputs must add up to: value, change and fee.
+func (t *Transaction) Cost() (amount.Amount, error) {
+	return amount.Sum(t.Value, t.Change, t.Fee)
+}
+
+// Size is the encoded length of the transaction in bytes.
+func (t *Transaction) Size() int {
+	m, _ := t.MarshalJSON()
+	return len(m)
+}
+
+// FeeRate is the fee paid per encoded byte. It is only used to rank
+// transactions, so the float is precise enough.
+func (t *Transaction) FeeRate() float64 {
+	return float64(t.Fee) / float64(t.Size())
 }
 
 func (t *Transaction) Print() {
 	fmt.Printf("%s\n", strings.Repeat("-", 40))
+	fmt.Printf(" id  %s\n", t.IDString())
 	fmt.Printf(" sender_blockchain_address  %s\n", t.SenderBlockchainAddress)
 	fmt.Printf(" recipient_blockchain_address  %s\n", t.RecipientBlockchainAddress)
-	fmt.Printf(" value  %.1f\n", t.Value)
+	fmt.Printf(" value  %s\n", t.Value)
+	fmt.Printf(" fee  %s\n", t.Fee)
+	fmt.Printf(" change  %s\n", t.Change)
+	for _, in := range t.Inputs {
+		fmt.Printf(" input  %s\n", in)
+	}
+	fmt.Printf(" nonce  %d\n", t.Nonce)
+	fmt.Printf(" timestamp  %d\n", t.Timestamp)
 }
 
 func (t *Transaction) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
-		SenderBlockchainAddress    string  `json:"senderBlockchainAddress"`
-		RecipientBlockchainAddress string  `json:"recipientBlockchainAddress"`
-		Value                      float32 `json:"value"`
+		SenderBlockchainAddress    string        `json:"senderBlockchainAddress"`
+		RecipientBlockchainAddress string        `json:"recipientBlockchainAddress"`
+		Value                      amount.Amount `json:"value"`
+		Fee                        amount.Amount `json:"fee,omitempty"`
+		Change                     amount.Amount `json:"change,omitempty"`
+		Inputs                     []OutPoint    `json:"inputs,omitempty"`
+		Nonce                      uint64        `json:"nonce"`
+		Timestamp                  int64         `json:"timestamp"`
+		SenderPublicKey            string        `json:"senderPublicKey,omitempty"`
+		Signature                  string        `json:"signature,omitempty"`
 	}{
 		SenderBlockchainAddress:    t.SenderBlockchainAddress,
 		RecipientBlockchainAddress: t.RecipientBlockchainAddress,
 		Value:                      t.Value,
+		Fee:                        t.Fee,
+		Change:                     t.Change,
+		Inputs:                     t.Inputs,
+		Nonce:                      t.Nonce,
+		Timestamp:                  t.Timestamp,
+		SenderPublicKey:            t.SenderPublicKey,
+		Signature:                  t.Signature,
 	})
 }
+
+func (t *Transaction) UnmarshalJSON(data []byte) error {
+	v := &struct {
+		SenderBlockchainAddress    *string        `json:"senderBlockchainAddress"`
+		RecipientBlockchainAddress *string        `json:"recipientBlockchainAddress"`
+		Value                      *amount.Amount `json:"value"`
+		Fee                        *amount.Amount `json:"fee"`
+		Change                     *amount.Amount `json:"change"`
+		Inputs                     *[]OutPoint    `json:"inputs"`
+		Nonce                      *uint64        `json:"nonce"`
+		Timestamp                  *int64         `json:"timestamp"`
+		SenderPublicKey            *string        `json:"senderPublicKey"`
+		Signature                  *string        `json:"signature"`
+	}{
+		SenderBlockchainAddress:    &t.SenderBlockchainAddress,
+		RecipientBlockchainAddress: &t.RecipientBlockchainAddress,
+		Value:                      &t.Value,
+		Fee:                        &t.Fee,
+		Change:                     &t.Change,
+		Inputs:                     &t.Inputs,
+		Nonce:                      &t.Nonce,
+		Timestamp:                  &t.Timestamp,
+		SenderPublicKey:            &t.SenderPublicKey,
+		Signature:                  &t.Signature,
+	}
+	return json.Unmarshal(data, v)
+}
@@ -4,10 +4,16 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"math/big"
 )
 
+var (
+	ErrMalformedPublicKey = errors.New("malformed public key")
+	ErrMalformedSignature = errors.New("malformed signature")
+)
+
 type Signature struct {
 	R *big.Int
 	S *big.Int
@@ -17,31 +23,61 @@ func (s *Signature) String() string {
 	return fmt.Sprintf("%064x%064x", s.R, s.S)
 }
 
-func String2BigIntTuple(s string) (big.Int, big.Int) {
-	bx, _ := hex.DecodeString(s[:64])
-	by, _ := hex.DecodeString(s[64:])
+func PublicKeyToString(publicKey *ecdsa.PublicKey) string {
+	return fmt.Sprintf("%064x%064x", publicKey.X, publicKey.Y)
+}
 
+// String2BigIntTuple decodes two concatenated 64 character hex numbers.
+func String2BigIntTuple(s string) (big.Int, big.Int, error) {
 	var bix big.Int
 	var biy big.Int
 
+	if len(s) != 128 {
+		return bix, biy, fmt.Errorf("expected 128 hex characters, got %d", len(s))
+	}
+
+	bx, err := hex.DecodeString(s[:64])
+	if err != nil {
+		return bix, biy, err
+	}
+	by, err := hex.DecodeString(s[64:])
+	if err != nil {
+		return bix, biy, err
+	}
+
 	bix.SetBytes(bx)
 	biy.SetBytes(by)
-	return bix, biy
+	return bix, biy, nil
 }
 
-func SignatureFromString(s string) *Signature {
-	x, y := String2BigIntTuple(s)
-	return &Signature{&x, &y}
+func SignatureFromString(s string) (*Signature, error) {
+	x, y, err := String2BigIntTuple(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedSignature, err)
+	}
+
+	if x.Sign() == 0 || y.Sign() == 0 {
+		return nil, fmt.Errorf("%w: zero component", ErrMalformedSignature)
+	}
+	return &Signature{&x, &y}, nil
 }
 
-func PublicKeyFromString(s string) *ecdsa.PublicKey {
-	x, y := String2BigIntTuple(s)
+func PublicKeyFromString(s string) (*ecdsa.PublicKey, error) {
+	x, y, err := String2BigIntTuple(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedPublicKey, err)
+	}
 
-	return &ecdsa.PublicKey{
-		elliptic.P256(),
-		&x,
-		&y,
+	curve := elliptic.P256()
+	if !curve.IsOnCurve(&x, &y) {
+		return nil, fmt.Errorf("%w: point is not on the curve", ErrMalformedPublicKey)
 	}
+
+	return &ecdsa.PublicKey{
+		Curve: curve,
+		X:     &x,
+		Y:     &y,
+	}, nil
 }
 
 func PrivateKeyFromString(s string, publicKey *ecdsa.PublicKey) *ecdsa.PrivateKey {
@@ -50,5 +86,5 @@ func PrivateKeyFromString(s string, publicKey *ecdsa.PublicKey) *ecdsa.PrivateKe
 	var bi big.Int
 	_ = bi.SetBytes(b)
 
-	return &ecdsa.PrivateKey{*publicKey, &bi}
+	return &ecdsa.PrivateKey{PublicKey: *publicKey, D: &bi}
 }
@@ -11,3 +11,17 @@ func JsonStatus(message string) []byte {
 
 	return m
 }
+
+// JsonError is JsonStatus with a machine-readable reason code that clients
+// can switch on instead of parsing the message.
+func JsonError(message string, reason string) []byte {
+	m, _ := json.Marshal(struct {
+		Message string `json:"message"`
+		Reason  string `json:"reason"`
+	}{
+		Message: message,
+		Reason:  reason,
+	})
+
+	return m
+}
@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+const NEIGHBOR_DIAL_TIMEOUT = 1 * time.Second
+
+func IsFoundHost(host string, port uint16) bool {
+	target := net.JoinHostPort(host, strconv.Itoa(int(port)))
+
+	conn, err := net.DialTimeout("tcp", target, NEIGHBOR_DIAL_TIMEOUT)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// FindNeighbors probes every host in [myHost+startIp, myHost+endIp] on every
+// port in [startPort, endPort] and returns the ones that accept a TCP
+// connection, leaving out the node's own address.
+func FindNeighbors(myHost string, myPort uint16, startIp uint8, endIp uint8, startPort uint16, endPort uint16) []string {
+	address := net.JoinHostPort(myHost, strconv.Itoa(int(myPort)))
+
+	ip := net.ParseIP(myHost).To4()
+	if ip == nil {
+		return nil
+	}
+
+	neighbors := make([]string, 0)
+	for port := int(startPort); port <= int(endPort); port++ {
+		for offset := int(startIp); offset <= int(endIp); offset++ {
+			lastOctet := int(ip[3]) + offset
+			if lastOctet > 255 {
+				break
+			}
+
+			guessHost := fmt.Sprintf("%d.%d.%d.%d", ip[0], ip[1], ip[2], lastOctet)
+			guessTarget := net.JoinHostPort(guessHost, strconv.Itoa(port))
+			if guessTarget == address {
+				continue
+			}
+
+			if IsFoundHost(guessHost, uint16(port)) {
+				neighbors = append(neighbors, guessTarget)
+			}
+		}
+	}
+	return neighbors
+}
+
+func GetHost() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "127.0.0.1"
+	}
+
+	addresses, err := net.LookupHost(hostname)
+	if err != nil {
+		return "127.0.0.1"
+	}
+
+	for _, a := range addresses {
+		if ip := net.ParseIP(a); ip != nil && ip.To4() != nil {
+			return a
+		}
+	}
+	return "127.0.0.1"
+}
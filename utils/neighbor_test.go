@@ -0,0 +1,100 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+)
+
+// listenRange listens on n consecutive ports of host and returns the
+// listeners in port order. They are closed when the test ends.
+func listenRange(t *testing.T, host string, n int) []net.Listener {
+	t.Helper()
+
+	for attempt := 0; attempt < 20; attempt++ {
+		l, err := net.Listen("tcp", net.JoinHostPort(host, "0"))
+		if err != nil {
+			t.Skipf("listen on %s: %v", host, err)
+		}
+		first := l.Addr().(*net.TCPAddr).Port
+		listeners := []net.Listener{l}
+		for port := first + 1; port < first+n && port <= 65535; port++ {
+			l, err := net.Listen("tcp", fmt.Sprintf("%s:%d", host, port))
+			if err != nil {
+				break
+			}
+			listeners = append(listeners, l)
+		}
+		if len(listeners) == n {
+			t.Cleanup(func() {
+				for _, l := range listeners {
+					l.Close()
+				}
+			})
+			return listeners
+		}
+		for _, l := range listeners {
+			l.Close()
+		}
+	}
+	t.Fatalf("no %d consecutive free ports on %s", n, host)
+	return nil
+}
+
+func port(l net.Listener) uint16 {
+	return uint16(l.Addr().(*net.TCPAddr).Port)
+}
+
+func TestIsFoundHost(t *testing.T) {
+	listeners := listenRange(t, "127.0.0.1", 2)
+	listeners[1].Close()
+
+	if !IsFoundHost("127.0.0.1", port(listeners[0])) {
+		t.Errorf("listening port %d not found", port(listeners[0]))
+	}
+	if IsFoundHost("127.0.0.1", port(listeners[1])) {
+		t.Errorf("closed port %d found", port(listeners[1]))
+	}
+}
+
+func TestFindNeighbors(t *testing.T) {
+	// The node itself listens on the first port, neighbors on the second
+	// and fourth; the third is closed.
+	listeners := listenRange(t, "127.0.0.1", 4)
+	listeners[2].Close()
+	first := port(listeners[0])
+
+	got := FindNeighbors("127.0.0.1", first, 0, 0, first, first+3)
+	want := []string{
+		fmt.Sprintf("127.0.0.1:%d", first+1),
+		fmt.Sprintf("127.0.0.1:%d", first+3),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("FindNeighbors = %v, want %v", got, want)
+	}
+}
+
+func TestFindNeighborsIPRange(t *testing.T) {
+	// Other hosts are reached through the last octet; the whole of
+	// 127.0.0.0/8 is loopback on Linux, so 127.0.0.2 stands in for one.
+	own := listenRange(t, "127.0.0.1", 1)
+	other, err := net.Listen("tcp", fmt.Sprintf("127.0.0.2:%d", port(own[0])))
+	if err != nil {
+		t.Skipf("127.0.0.2 not usable: %v", err)
+	}
+	defer other.Close()
+
+	p := port(own[0])
+	got := FindNeighbors("127.0.0.1", p, 0, 2, p, p)
+	want := []string{fmt.Sprintf("127.0.0.2:%d", p)}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("FindNeighbors = %v, want %v", got, want)
+	}
+}
+
+func TestFindNeighborsInvalidHost(t *testing.T) {
+	if got := FindNeighbors("not-an-ip", 5000, 0, 3, 5000, 5003); got != nil {
+		t.Errorf("FindNeighbors = %v, want nil", got)
+	}
+}
@@ -0,0 +1,309 @@
+package utxo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/Ethical-Ralph/go-block/amount"
+	"github.com/Ethical-Ralph/go-block/transaction"
+)
+
+var (
+	ErrMissingInput       = errors.New("input is not an unspent output")
+	ErrInputNotOwned      = errors.New("input belongs to another address")
+	ErrDuplicateInput     = errors.New("input spent twice")
+	ErrInsufficientInputs = errors.New("inputs do not cover outputs and fee")
+	ErrUnbalanced         = errors.New("inputs exceed outputs and fee")
+)
+
+// Entry is an unspent output together with where it is.
+type Entry struct {
+	OutPoint transaction.OutPoint
+	Output   transaction.Output
+}
+
+func (e Entry) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		TxID    string        `json:"txId"`
+		Index   uint32        `json:"index"`
+		Address string        `json:"address"`
+		Value   amount.Amount `json:"value"`
+	}{
+		TxID:    fmt.Sprintf("%x", e.OutPoint.TxID),
+		Index:   e.OutPoint.Index,
+		Address: e.Output.Address,
+		Value:   e.Output.Value,
+	})
+}
+
+func (e *Entry) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &e.OutPoint); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &e.Output)
+}
+
+// Source looks up unspent outputs. Both Set and View are sources.
+type Source interface {
+	Get(op transaction.OutPoint) (transaction.Output, bool)
+}
+
+// Set is the set of unspent outputs, indexed by outpoint and by address,
+// with the balance of every address kept up to date as outputs come and go.
+// It is not safe for concurrent use; the blockchain guards it.
+type Set struct {
+	outputs   map[transaction.OutPoint]transaction.Output
+	byAddress map[string]map[transaction.OutPoint]bool
+	balances  map[string]amount.Amount
+}
+
+func NewSet() *Set {
+	return &Set{
+		outputs:   make(map[transaction.OutPoint]transaction.Output),
+		byAddress: make(map[string]map[transaction.OutPoint]bool),
+		balances:  make(map[string]amount.Amount),
+	}
+}
+
+func (s *Set) Get(op transaction.OutPoint) (transaction.Output, bool) {
+	out, ok := s.outputs[op]
+	return out, ok
+}
+
+func (s *Set) add(op transaction.OutPoint, out transaction.Output) {
+	s.outputs[op] = out
+	if s.byAddress[out.Address] == nil {
+		s.byAddress[out.Address] = make(map[transaction.OutPoint]bool)
+	}
+	s.byAddress[out.Address][op] = true
+	// Balances cannot overflow: every coin in the set was minted by a
+	// coinbase, and their total stays far below amount.MAX.
+	s.balances[out.Address] += out.Value
+}
+
+func (s *Set) spend(op transaction.OutPoint) (transaction.Output, bool) {
+	out, ok := s.outputs[op]
+	if !ok {
+		return out, false
+	}
+	delete(s.outputs, op)
+	delete(s.byAddress[out.Address], op)
+	s.balances[out.Address] -= out.Value
+	if len(s.byAddress[out.Address]) == 0 {
+		delete(s.byAddress, out.Address)
+		delete(s.balances, out.Address)
+	}
+	return out, true
+}
+
+// Apply spends the inputs of t and adds its outputs. t must have passed
+// Check against s first, except for the coinbase, which has no inputs.
+func (s *Set) Apply(t *transaction.Transaction) {
+	s.apply(t, nil)
+}
+
+func (s *Set) apply(t *transaction.Transaction, u *Undo) {
+	for _, in := range t.Inputs {
+		out, ok := s.spend(in)
+		if ok && u != nil {
+			u.changes = append(u.changes, change{entry: Entry{OutPoint: in, Output: out}, spent: true})
+		}
+	}
+	for _, e := range outputsOf(t) {
+		s.add(e.OutPoint, e.Output)
+		if u != nil {
+			u.changes = append(u.changes, change{entry: e})
+		}
+	}
+}
+
+// Undo records what connecting a block did to a Set, so that Disconnect can
+// take the block off again.
+type Undo struct {
+	changes []change
+}
+
+type change struct {
+	entry Entry
+	spent bool
+}
+
+// Connect applies the transactions of a block in order and returns how to
+// undo them.
+func (s *Set) Connect(transactions []*transaction.Transaction) *Undo {
+	u := &Undo{changes: make([]change, 0)}
+	for _, t := range transactions {
+		s.apply(t, u)
+	}
+	return u
+}
+
+// Disconnect reverts a Connect. Blocks must be disconnected newest first, so
+// that s is back in the state the block was connected to.
+func (s *Set) Disconnect(u *Undo) {
+	for i := len(u.changes) - 1; i >= 0; i-- {
+		c := u.changes[i]
+		if c.spent {
+			s.add(c.entry.OutPoint, c.entry.Output)
+		} else {
+			s.spend(c.entry.OutPoint)
+		}
+	}
+}
+
+// ByAddress lists the unspent outputs of address in outpoint order.
+func (s *Set) ByAddress(address string) []Entry {
+	entries := make([]Entry, 0, len(s.byAddress[address]))
+	for op := range s.byAddress[address] {
+		entries = append(entries, Entry{OutPoint: op, Output: s.outputs[op]})
+	}
+	sortEntries(entries)
+	return entries
+}
+
+// Balance is the sum of the unspent outputs of address.
+func (s *Set) Balance(address string) amount.Amount {
+	return s.balances[address]
+}
+
+// View layers transactions over a Set without changing it, for checking
+// transactions that build on pending ones.
+type View struct {
+	base    *Set
+	spent   map[transaction.OutPoint]bool
+	created map[transaction.OutPoint]transaction.Output
+}
+
+func NewView(base *Set) *View {
+	return &View{
+		base:    base,
+		spent:   make(map[transaction.OutPoint]bool),
+		created: make(map[transaction.OutPoint]transaction.Output),
+	}
+}
+
+func (v *View) Get(op transaction.OutPoint) (transaction.Output, bool) {
+	if v.spent[op] {
+		return transaction.Output{}, false
+	}
+	if out, ok := v.created[op]; ok {
+		return out, true
+	}
+	return v.base.Get(op)
+}
+
+// Apply is Set.Apply on the view.
+func (v *View) Apply(t *transaction.Transaction) {
+	for _, in := range t.Inputs {
+		v.spent[in] = true
+	}
+	for _, e := range outputsOf(t) {
+		v.created[e.OutPoint] = e.Output
+	}
+}
+
+// ByAddress lists the outputs of address unspent in the view, in outpoint
+// order.
+func (v *View) ByAddress(address string) []Entry {
+	entries := make([]Entry, 0)
+	for _, e := range v.base.ByAddress(address) {
+		if !v.spent[e.OutPoint] {
+			entries = append(entries, e)
+		}
+	}
+	for op, out := range v.created {
+		if out.Address == address && !v.spent[op] {
+			entries = append(entries, Entry{OutPoint: op, Output: out})
+		}
+	}
+	sortEntries(entries)
+	return entries
+}
+
+// Check verifies that every input of t is unspent in src, owned by the
+// sender and used once, and that the inputs add up to exactly the value,
+// change and fee of t.
+func Check(src Source, t *transaction.Transaction) error {
+	cost, err := t.Cost()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnbalanced, err)
+	}
+
+	used := make(map[transaction.OutPoint]bool)
+	var total amount.Amount = 0
+	for _, in := range t.Inputs {
+		if used[in] {
+			return fmt.Errorf("%w: %s", ErrDuplicateInput, in)
+		}
+		used[in] = true
+
+		out, ok := src.Get(in)
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrMissingInput, in)
+		}
+		if out.Address != t.SenderBlockchainAddress {
+			return fmt.Errorf("%w: %s", ErrInputNotOwned, in)
+		}
+		if total, err = total.Add(out.Value); err != nil {
+			return fmt.Errorf("%w: %v", ErrUnbalanced, err)
+		}
+	}
+
+	if total < cost {
+		return fmt.Errorf("%w: inputs %s, needed %s", ErrInsufficientInputs, total, cost)
+	}
+	if total > cost {
+		return fmt.Errorf("%w: inputs %s, needed %s", ErrUnbalanced, total, cost)
+	}
+	return nil
+}
+
+// Select picks outputs from candidates, largest first, until they cover
+// target. The choice only depends on the candidates, so the same wallet
+// state always spends the same outputs.
+func Select(candidates []Entry, target amount.Amount) ([]Entry, amount.Amount, error) {
+	sorted := append([]Entry{}, candidates...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Output.Value != sorted[j].Output.Value {
+			return sorted[i].Output.Value > sorted[j].Output.Value
+		}
+		return sorted[i].OutPoint.Less(sorted[j].OutPoint)
+	})
+
+	selected := make([]Entry, 0)
+	var total amount.Amount = 0
+	for _, e := range sorted {
+		if total >= target {
+			break
+		}
+		var err error
+		if total, err = total.Add(e.Output.Value); err != nil {
+			return nil, 0, err
+		}
+		selected = append(selected, e)
+	}
+	if total < target {
+		return nil, 0, fmt.Errorf("%w: have %s, need %s", ErrInsufficientInputs, total, target)
+	}
+	return selected, total, nil
+}
+
+// outputsOf lists the outputs t creates. Zero value outputs are skipped:
+// they could never be worth spending.
+func outputsOf(t *transaction.Transaction) []Entry {
+	id := t.ID()
+	entries := make([]Entry, 0, 2)
+	for i, out := range t.Outputs() {
+		if out.Value == 0 {
+			continue
+		}
+		entries = append(entries, Entry{OutPoint: transaction.OutPoint{TxID: id, Index: uint32(i)}, Output: out})
+	}
+	return entries
+}
+
+func sortEntries(entries []Entry) {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].OutPoint.Less(entries[j].OutPoint) })
+}
@@ -0,0 +1,117 @@
+package utxo
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/Ethical-Ralph/go-block/amount"
+	"github.com/Ethical-Ralph/go-block/transaction"
+)
+
+func coinbase(miner string, height uint64) *transaction.Transaction {
+	return transaction.NewTransaction("THE BLOCKCHAIN", miner, amount.COIN, 0, height, 1)
+}
+
+// spend is a transaction of sender spending inputs for value to recipient,
+// with fee and the rest going back as change.
+func spend(sender string, recipient string, value amount.Amount, fee amount.Amount, inputs ...Entry) *transaction.Transaction {
+	t := transaction.NewTransaction(sender, recipient, value, fee, 0, 2)
+	var total amount.Amount = 0
+	for _, e := range inputs {
+		t.Inputs = append(t.Inputs, e.OutPoint)
+		total += e.Output.Value
+	}
+	t.Change = total - value - fee
+	return t
+}
+
+type snapshot struct {
+	outputs  map[string][]Entry
+	balances map[string]amount.Amount
+}
+
+func snapshotOf(s *Set, addresses ...string) snapshot {
+	snap := snapshot{outputs: make(map[string][]Entry), balances: make(map[string]amount.Amount)}
+	for _, a := range addresses {
+		snap.outputs[a] = s.ByAddress(a)
+		snap.balances[a] = s.Balance(a)
+	}
+	return snap
+}
+
+func TestConnectDisconnect(t *testing.T) {
+	s := NewSet()
+	u1 := s.Connect([]*transaction.Transaction{coinbase("A", 1)})
+	before := snapshotOf(s, "A", "B")
+
+	// A pays B from its coinbase, and B pays part of it on in the same block.
+	pay := spend("A", "B", amount.COIN/2, amount.COIN/10, s.ByAddress("A")...)
+	if err := Check(s, pay); err != nil {
+		t.Fatal(err)
+	}
+	view := NewView(s)
+	view.Apply(pay)
+	onward := spend("B", "A", amount.COIN/4, 0, view.ByAddress("B")...)
+	if err := Check(view, onward); err != nil {
+		t.Fatal(err)
+	}
+
+	u2 := s.Connect([]*transaction.Transaction{pay, onward, coinbase("B", 2)})
+	want := map[string]amount.Amount{
+		"A": amount.COIN/2 - amount.COIN/10 + amount.COIN/4,
+		"B": amount.COIN/4 + amount.COIN,
+	}
+	for a, b := range want {
+		if got := s.Balance(a); got != b {
+			t.Errorf("Balance(%s) = %s, want %s", a, got, b)
+		}
+	}
+	if err := Check(s, pay); !errors.Is(err, ErrMissingInput) {
+		t.Errorf("Check of a spent input = %v, want %v", err, ErrMissingInput)
+	}
+
+	s.Disconnect(u2)
+	if after := snapshotOf(s, "A", "B"); !reflect.DeepEqual(after, before) {
+		t.Errorf("after Disconnect %+v, want %+v", after, before)
+	}
+	if err := Check(s, pay); err != nil {
+		t.Errorf("Check after Disconnect = %v", err)
+	}
+
+	s.Disconnect(u1)
+	if len(s.outputs) != 0 || len(s.byAddress) != 0 || len(s.balances) != 0 {
+		t.Errorf("set not empty after disconnecting every block")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	s := NewSet()
+	s.Connect([]*transaction.Transaction{coinbase("A", 1), coinbase("B", 2)})
+	a, b := s.ByAddress("A"), s.ByAddress("B")
+
+	tests := []struct {
+		name string
+		t    *transaction.Transaction
+		want error
+	}{
+		{"other's input", spend("A", "C", amount.COIN, 0, b...), ErrInputNotOwned},
+		{"duplicate input", spend("A", "C", amount.COIN, 0, a[0], a[0]), ErrDuplicateInput},
+	}
+	for _, tt := range tests {
+		if err := Check(s, tt.t); !errors.Is(err, tt.want) {
+			t.Errorf("%s: Check = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	short := spend("A", "C", amount.COIN, amount.COIN/10, a...)
+	short.Change = 0
+	if err := Check(s, short); !errors.Is(err, ErrInsufficientInputs) {
+		t.Errorf("Check with inputs short of the fee = %v, want %v", err, ErrInsufficientInputs)
+	}
+	over := spend("A", "C", amount.COIN/2, 0, a...)
+	over.Change = 0
+	if err := Check(s, over); !errors.Is(err, ErrUnbalanced) {
+		t.Errorf("Check with inputs left over = %v, want %v", err, ErrUnbalanced)
+	}
+}
@@ -0,0 +1,51 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcutil/base58"
+)
+
+const (
+	ADDRESS_VERSION      = 0x00
+	ADDRESS_LENGTH_BYTES = 25
+	ADDRESS_CHECKSUM_LEN = 4
+)
+
+var ErrInvalidAddress = errors.New("invalid blockchain address")
+
+// addressChecksum is the first 4 bytes of SHA-256(payload), where payload
+// is the version byte followed by the RIPEMD-160 hash. Unlike Bitcoin it is
+// a single SHA-256, as in every address issued so far.
+func addressChecksum(payload []byte) []byte {
+	h := sha256.Sum256(payload)
+	return h[:ADDRESS_CHECKSUM_LEN]
+}
+
+// DecodeAddress checks the length, version byte and checksum of a base58
+// address and returns the RIPEMD-160 public key hash it carries.
+func DecodeAddress(address string) ([]byte, error) {
+	decoded := base58.Decode(address)
+	if len(decoded) != ADDRESS_LENGTH_BYTES {
+		return nil, fmt.Errorf("%w: %q decodes to %d bytes", ErrInvalidAddress, address, len(decoded))
+	}
+
+	payload := decoded[:ADDRESS_LENGTH_BYTES-ADDRESS_CHECKSUM_LEN]
+	checksum := decoded[ADDRESS_LENGTH_BYTES-ADDRESS_CHECKSUM_LEN:]
+
+	if payload[0] != ADDRESS_VERSION {
+		return nil, fmt.Errorf("%w: %q has version %#x", ErrInvalidAddress, address, payload[0])
+	}
+	if !bytes.Equal(checksum, addressChecksum(payload)) {
+		return nil, fmt.Errorf("%w: %q has a bad checksum", ErrInvalidAddress, address)
+	}
+	return payload[1:], nil
+}
+
+func ValidateAddress(address string) error {
+	_, err := DecodeAddress(address)
+	return err
+}
@@ -0,0 +1,76 @@
+package wallet
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The address the original wallet code gives this key. Addresses issued
+// before checksums were validated must stay valid.
+const (
+	knownPrivateKey = "62c5b08cc0cee20013eae36a3e0d0a4312f0ab0c77c8a5dbc832abc121be999e"
+	knownAddress    = "1FCPFzheUPc6RbvaysPQkHAvrgA4UH2CoU"
+)
+
+func TestAddressFromPublicKey(t *testing.T) {
+	w, err := NewWalletFromPrivateKeyStr(knownPrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.BlockchainAddress() != knownAddress {
+		t.Errorf("address = %s, want %s", w.BlockchainAddress(), knownAddress)
+	}
+	if err := ValidateAddress(knownAddress); err != nil {
+		t.Errorf("ValidateAddress(%s) = %v", knownAddress, err)
+	}
+}
+
+func TestValidateAddressInvalid(t *testing.T) {
+	for _, address := range []string{
+		"",
+		"1FCPFzheUPc6RbvaysPQkHAvrgA4UH2CoV",  // checksum
+		"1FCPFzheUPc6RbvaysPQkHAvrgA4RHibnx",  // double SHA-256 checksum
+		"1FCPFzheUPc6RbvaysPQkHAvrgA4UH2CoUU", // length
+		"0OIl",
+	} {
+		if err := ValidateAddress(address); !errors.Is(err, ErrInvalidAddress) {
+			t.Errorf("ValidateAddress(%q) = %v, want %v", address, err, ErrInvalidAddress)
+		}
+	}
+}
+
+func TestLoadWallet(t *testing.T) {
+	w, err := NewWalletFromPrivateKeyStr(knownPrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "wallet.json")
+	if err := w.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadWallet(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.BlockchainAddress() != knownAddress {
+		t.Errorf("loaded address = %s, want %s", loaded.BlockchainAddress(), knownAddress)
+	}
+
+	var v map[string]string
+	data, _ := os.ReadFile(path)
+	if err := json.Unmarshal(data, &v); err != nil {
+		t.Fatal(err)
+	}
+	v["blockchainAddress"] = NewWallet().BlockchainAddress()
+	data, _ = json.Marshal(v)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadWallet(path); err == nil {
+		t.Error("LoadWallet accepted an address that does not match the key")
+	}
+}
@@ -0,0 +1,227 @@
+package wallet
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+const (
+	// HD_HARDENED_OFFSET marks a child index as hardened, written i' in a path.
+	HD_HARDENED_OFFSET = 0x80000000
+
+	// HD_DEFAULT_PATH is the BIP-44 style account path; the address index is
+	// appended to it.
+	HD_DEFAULT_PATH = "m/44'/0'/0'/0"
+
+	// Keys are derived as in SLIP-0010, which carries BIP-32 over to the
+	// P-256 curve used by the rest of the wallet.
+	hdMasterKey = "Nist256p1 seed"
+)
+
+var ErrInvalidPath = errors.New("invalid derivation path")
+
+// ExtendedKey is a private key together with the chain code needed to
+// derive its children.
+type ExtendedKey struct {
+	key       *big.Int
+	chainCode []byte
+	depth     uint8
+	index     uint32
+}
+
+// NewMasterKey derives the root of the key tree from a seed, usually the
+// output of MnemonicToSeed.
+func NewMasterKey(seed []byte) (*ExtendedKey, error) {
+	if len(seed) < 16 || len(seed) > 64 {
+		return nil, errors.New("seed must be 16 to 64 bytes")
+	}
+
+	n := elliptic.P256().Params().N
+	data := seed
+	for {
+		mac := hmac.New(sha512.New, []byte(hdMasterKey))
+		mac.Write(data)
+		I := mac.Sum(nil)
+
+		k := new(big.Int).SetBytes(I[:32])
+		if k.Sign() != 0 && k.Cmp(n) < 0 {
+			return &ExtendedKey{key: k, chainCode: I[32:]}, nil
+		}
+		data = I
+	}
+}
+
+func (k *ExtendedKey) Depth() uint8 {
+	return k.depth
+}
+
+func (k *ExtendedKey) Index() uint32 {
+	return k.index
+}
+
+// Child derives the child key at index. Indexes at or above
+// HD_HARDENED_OFFSET are hardened and cannot be derived from a public key.
+func (k *ExtendedKey) Child(index uint32) (*ExtendedKey, error) {
+	if k.depth == 255 {
+		return nil, errors.New("maximum derivation depth reached")
+	}
+
+	curve := elliptic.P256()
+	n := curve.Params().N
+
+	var data []byte
+	if index >= HD_HARDENED_OFFSET {
+		data = make([]byte, 33)
+		k.key.FillBytes(data[1:])
+	} else {
+		x, y := curve.ScalarBaseMult(k.key.Bytes())
+		data = elliptic.MarshalCompressed(curve, x, y)
+	}
+	data = appendUint32(data, index)
+
+	for {
+		mac := hmac.New(sha512.New, k.chainCode)
+		mac.Write(data)
+		I := mac.Sum(nil)
+
+		il := new(big.Int).SetBytes(I[:32])
+		child := new(big.Int).Add(il, k.key)
+		child.Mod(child, n)
+		if il.Cmp(n) < 0 && child.Sign() != 0 {
+			return &ExtendedKey{
+				key:       child,
+				chainCode: I[32:],
+				depth:     k.depth + 1,
+				index:     index,
+			}, nil
+		}
+
+		// Invalid key: retry with 0x01 || IR || index as SLIP-0010 requires.
+		data = append([]byte{0x01}, I[32:]...)
+		data = appendUint32(data, index)
+	}
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+// Derive walks a path such as "m/44'/0'/0'/0/7" from k, which must be the
+// master key.
+func (k *ExtendedKey) Derive(path string) (*ExtendedKey, error) {
+	indexes, err := ParsePath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key := k
+	for _, i := range indexes {
+		key, err = key.Child(i)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+func (k *ExtendedKey) PrivateKey() *ecdsa.PrivateKey {
+	privateKey := new(ecdsa.PrivateKey)
+	privateKey.Curve = elliptic.P256()
+	privateKey.D = new(big.Int).Set(k.key)
+	privateKey.X, privateKey.Y = privateKey.Curve.ScalarBaseMult(k.key.Bytes())
+	return privateKey
+}
+
+// Wallet returns the wallet for this key, with its address built by the
+// same pipeline as NewWallet.
+func (k *ExtendedKey) Wallet() *Wallet {
+	return NewWalletFromPrivateKey(k.PrivateKey())
+}
+
+// ParsePath turns "m/44'/0'/0'/0/7" into child indexes. Hardened levels may
+// be marked with ' or h.
+func ParsePath(path string) ([]uint32, error) {
+	parts := strings.Split(strings.TrimSpace(path), "/")
+	if len(parts) == 0 || parts[0] != "m" {
+		return nil, fmt.Errorf("%w: %q must start with m", ErrInvalidPath, path)
+	}
+
+	indexes := make([]uint32, 0, len(parts)-1)
+	for _, p := range parts[1:] {
+		var offset uint32 = 0
+		if strings.HasSuffix(p, "'") || strings.HasSuffix(p, "h") {
+			offset = HD_HARDENED_OFFSET
+			p = p[:len(p)-1]
+		}
+
+		i, err := strconv.ParseUint(p, 10, 32)
+		if err != nil || i >= HD_HARDENED_OFFSET {
+			return nil, fmt.Errorf("%w: bad level %q in %q", ErrInvalidPath, p, path)
+		}
+		indexes = append(indexes, uint32(i)+offset)
+	}
+	return indexes, nil
+}
+
+// HDWallet hands out deterministic wallets from a single mnemonic, so one
+// phrase backs up every address.
+type HDWallet struct {
+	mnemonic string
+	master   *ExtendedKey
+}
+
+// NewHDWallet creates an HD wallet with a fresh 12 word mnemonic.
+func NewHDWallet(passphrase string) (*HDWallet, error) {
+	mnemonic, err := NewMnemonic(MNEMONIC_ENTROPY_BITS)
+	if err != nil {
+		return nil, err
+	}
+	return RestoreHDWallet(mnemonic, passphrase)
+}
+
+// RestoreHDWallet recreates the HD wallet behind a mnemonic. The same
+// mnemonic and passphrase always yield the same addresses.
+func RestoreHDWallet(mnemonic string, passphrase string) (*HDWallet, error) {
+	seed, err := MnemonicToSeed(mnemonic, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	master, err := NewMasterKey(seed)
+	if err != nil {
+		return nil, err
+	}
+	return &HDWallet{mnemonic: strings.Join(strings.Fields(mnemonic), " "), master: master}, nil
+}
+
+func (hd *HDWallet) Mnemonic() string {
+	return hd.mnemonic
+}
+
+func (hd *HDWallet) MasterKey() *ExtendedKey {
+	return hd.master
+}
+
+// Derive returns the wallet at an arbitrary path.
+func (hd *HDWallet) Derive(path string) (*Wallet, error) {
+	key, err := hd.master.Derive(path)
+	if err != nil {
+		return nil, err
+	}
+	return key.Wallet(), nil
+}
+
+// Wallet returns the wallet at index under HD_DEFAULT_PATH.
+func (hd *HDWallet) Wallet(index uint32) (*Wallet, error) {
+	return hd.Derive(fmt.Sprintf("%s/%d", HD_DEFAULT_PATH, index))
+}
@@ -0,0 +1,135 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+// trezorMnemonic is the first BIP-39 test vector: 16 zero bytes of entropy,
+// used with the passphrase "TREZOR".
+const trezorMnemonic = "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestMnemonicVector(t *testing.T) {
+	mnemonic, err := EntropyToMnemonic(make([]byte, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mnemonic != trezorMnemonic {
+		t.Errorf("EntropyToMnemonic = %q, want %q", mnemonic, trezorMnemonic)
+	}
+
+	seed, err := MnemonicToSeed(trezorMnemonic, "TREZOR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := mustHex(t, "c55257c360c07c72029aebc1b53c05ed0362ada38ead3e3e9efa3708e53495531f09a6987599d18264c1e1c92f2cf141630c7a3c4ab7c81b2f001698e7463b04")
+	if !bytes.Equal(seed, want) {
+		t.Errorf("MnemonicToSeed = %x, want %x", seed, want)
+	}
+}
+
+// TestSLIP10Vectors checks test vector 1 for nist256p1 from SLIP-0010.
+func TestSLIP10Vectors(t *testing.T) {
+	master, err := NewMasterKey(mustHex(t, "000102030405060708090a0b0c0d0e0f"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path      string
+		chainCode string
+		private   string
+		public    string
+	}{
+		{
+			"m",
+			"beeb672fe4621673f722f38529c07392fecaa61015c80c34f29ce8b41b3cb6ea",
+			"612091aaa12e22dd2abef664f8a01a82cae99ad7441b7ef8110424915c268bc2",
+			"0266874dc6ade47b3ecd096745ca09bcd29638dd52c2c12117b11ed3e458cfa9e8",
+		},
+		{
+			"m/0'",
+			"3460cea53e6a6bb5fb391eeef3237ffd8724bf0a40e94943c98b83825342ee11",
+			"6939694369114c67917a182c59ddb8cafc3004e63ca5d3b84403ba8613debc0c",
+			"0384610f5ecffe8fda089363a41f56a5c7ffc1d81b59a612d0d649b2d22355590c",
+		},
+		{
+			"m/0'/1/2'/2/1000000000",
+			"b9b7b82d326bb9cb5b5b121066feea4eb93d5241103c9e7a18aad40f1dde8059",
+			"21c4f269ef0a5fd1badf47eeacebeeaa3de22eb8e5b0adcd0f27dd99d34d0119",
+			"02216cd26d31147f72427a453c443ed2cde8a1e53c9cc44e5ddf739725413fe3f4",
+		},
+	}
+	for _, tt := range tests {
+		k, err := master.Derive(tt.path)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.path, err)
+		}
+
+		privateKey := k.PrivateKey()
+		public := elliptic.MarshalCompressed(privateKey.Curve, privateKey.X, privateKey.Y)
+		if got := hex.EncodeToString(k.chainCode); got != tt.chainCode {
+			t.Errorf("%s: chain code %s, want %s", tt.path, got, tt.chainCode)
+		}
+		if got := hex.EncodeToString(privateKey.D.FillBytes(make([]byte, 32))); got != tt.private {
+			t.Errorf("%s: private key %s, want %s", tt.path, got, tt.private)
+		}
+		if got := hex.EncodeToString(public); got != tt.public {
+			t.Errorf("%s: public key %s, want %s", tt.path, got, tt.public)
+		}
+	}
+}
+
+func TestRestoreHDWallet(t *testing.T) {
+	hd, err := RestoreHDWallet(" "+trezorMnemonic+"  ", "TREZOR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hd.Mnemonic() != trezorMnemonic {
+		t.Errorf("Mnemonic = %q, want %q", hd.Mnemonic(), trezorMnemonic)
+	}
+
+	w, err := hd.Wallet(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "1FCPFzheUPc6RbvaysPQkHAvrgA4UH2CoU"
+	if w.BlockchainAddress() != want {
+		t.Errorf("address of %s/0 = %s, want %s", HD_DEFAULT_PATH, w.BlockchainAddress(), want)
+	}
+	if err := ValidateAddress(w.BlockchainAddress()); err != nil {
+		t.Errorf("ValidateAddress(%s) = %v", w.BlockchainAddress(), err)
+	}
+
+	other, err := RestoreHDWallet(trezorMnemonic, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w2, _ := other.Wallet(0); w2.BlockchainAddress() == want {
+		t.Error("passphrase does not change the derived wallet")
+	}
+}
+
+func TestRestoreHDWalletInvalid(t *testing.T) {
+	bad := "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon"
+	if _, err := RestoreHDWallet(bad, ""); !errors.Is(err, ErrInvalidMnemonic) {
+		t.Errorf("RestoreHDWallet with bad checksum = %v, want %v", err, ErrInvalidMnemonic)
+	}
+	for _, path := range []string{"", "44'/0'", "m/x", "m/2147483648"} {
+		if _, err := ParsePath(path); !errors.Is(err, ErrInvalidPath) {
+			t.Errorf("ParsePath(%q) = %v, want %v", path, err, ErrInvalidPath)
+		}
+	}
+}
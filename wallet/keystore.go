@@ -0,0 +1,215 @@
+package wallet
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	KEYSTORE_VERSION = 1
+	KEYSTORE_CIPHER  = "aes-256-gcm"
+	KEYSTORE_KDF     = "scrypt"
+
+	KEYSTORE_SCRYPT_N     = 1 << 18
+	KEYSTORE_SCRYPT_R     = 8
+	KEYSTORE_SCRYPT_P     = 1
+	KEYSTORE_SCRYPT_DKLEN = 32
+	KEYSTORE_SALT_LEN     = 32
+
+	// Limits on the scrypt parameters of imported files, so a crafted file
+	// cannot make Import take unbounded memory or time. scrypt needs
+	// 128*N*r bytes.
+	KEYSTORE_SCRYPT_MAX_N   = 1 << 20
+	KEYSTORE_SCRYPT_MAX_RP  = 16
+	KEYSTORE_SCRYPT_MAX_MEM = 1 << 30
+)
+
+var ErrDecryptKeystore = errors.New("could not decrypt keystore: wrong passphrase or corrupted file")
+
+// keystoreJSON is the encrypted wallet file, modelled on the Ethereum v3
+// keystore. The GCM tag is stored apart from the ciphertext as the MAC, and
+// the address is authenticated as additional data so it cannot be swapped.
+type keystoreJSON struct {
+	Version int          `json:"version"`
+	Address string       `json:"address"`
+	Crypto  keystoreData `json:"crypto"`
+}
+
+type keystoreData struct {
+	Cipher       string               `json:"cipher"`
+	CipherText   string               `json:"ciphertext"`
+	CipherParams keystoreCipherParams `json:"cipherparams"`
+	KDF          string               `json:"kdf"`
+	KDFParams    keystoreKDFParams    `json:"kdfparams"`
+	MAC          string               `json:"mac"`
+}
+
+type keystoreCipherParams struct {
+	Nonce string `json:"nonce"`
+}
+
+type keystoreKDFParams struct {
+	N     int    `json:"n"`
+	R     int    `json:"r"`
+	P     int    `json:"p"`
+	DKLen int    `json:"dklen"`
+	Salt  string `json:"salt"`
+}
+
+// Export encrypts the wallet's private key with a key derived from
+// passphrase and returns the keystore JSON.
+func (w *Wallet) Export(passphrase string) ([]byte, error) {
+	salt := make([]byte, KEYSTORE_SALT_LEN)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	key, err := scrypt.Key([]byte(passphrase), salt,
+		KEYSTORE_SCRYPT_N, KEYSTORE_SCRYPT_R, KEYSTORE_SCRYPT_P, KEYSTORE_SCRYPT_DKLEN)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newKeystoreAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	plaintext := make([]byte, 32)
+	w.privateKey.D.FillBytes(plaintext)
+
+	sealed := aead.Seal(nil, nonce, plaintext, []byte(w.BlockchainAddress()))
+	tagStart := len(sealed) - aead.Overhead()
+
+	return json.MarshalIndent(&keystoreJSON{
+		Version: KEYSTORE_VERSION,
+		Address: w.BlockchainAddress(),
+		Crypto: keystoreData{
+			Cipher:       KEYSTORE_CIPHER,
+			CipherText:   hex.EncodeToString(sealed[:tagStart]),
+			CipherParams: keystoreCipherParams{Nonce: hex.EncodeToString(nonce)},
+			KDF:          KEYSTORE_KDF,
+			KDFParams: keystoreKDFParams{
+				N:     KEYSTORE_SCRYPT_N,
+				R:     KEYSTORE_SCRYPT_R,
+				P:     KEYSTORE_SCRYPT_P,
+				DKLen: KEYSTORE_SCRYPT_DKLEN,
+				Salt:  hex.EncodeToString(salt),
+			},
+			MAC: hex.EncodeToString(sealed[tagStart:]),
+		},
+	}, "", "  ")
+}
+
+// Import decrypts keystore JSON produced by Export.
+func Import(data []byte, passphrase string) (*Wallet, error) {
+	var ks keystoreJSON
+	if err := json.Unmarshal(data, &ks); err != nil {
+		return nil, err
+	}
+
+	if ks.Version != KEYSTORE_VERSION {
+		return nil, fmt.Errorf("unsupported keystore version %d", ks.Version)
+	}
+	if ks.Crypto.Cipher != KEYSTORE_CIPHER || ks.Crypto.KDF != KEYSTORE_KDF {
+		return nil, fmt.Errorf("unsupported keystore cipher %q or kdf %q", ks.Crypto.Cipher, ks.Crypto.KDF)
+	}
+
+	params := ks.Crypto.KDFParams
+	if params.DKLen != KEYSTORE_SCRYPT_DKLEN {
+		return nil, fmt.Errorf("unsupported keystore dklen %d", params.DKLen)
+	}
+	if params.N <= 1 || params.N > KEYSTORE_SCRYPT_MAX_N ||
+		params.R <= 0 || params.R > KEYSTORE_SCRYPT_MAX_RP || params.P <= 0 || params.P > KEYSTORE_SCRYPT_MAX_RP ||
+		params.R*params.P > KEYSTORE_SCRYPT_MAX_RP || 128*params.N*params.R > KEYSTORE_SCRYPT_MAX_MEM {
+		return nil, fmt.Errorf("unsupported keystore scrypt parameters n=%d, r=%d, p=%d", params.N, params.R, params.P)
+	}
+
+	salt, err := hex.DecodeString(params.Salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hex.DecodeString(ks.Crypto.CipherParams.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := hex.DecodeString(ks.Crypto.CipherText)
+	if err != nil {
+		return nil, err
+	}
+	mac, err := hex.DecodeString(ks.Crypto.MAC)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := scrypt.Key([]byte(passphrase), salt, params.N, params.R, params.P, params.DKLen)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newKeystoreAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != aead.NonceSize() || len(mac) != aead.Overhead() {
+		return nil, ErrDecryptKeystore
+	}
+
+	plaintext, err := aead.Open(nil, nonce, append(ciphertext, mac...), []byte(ks.Address))
+	if err != nil {
+		return nil, ErrDecryptKeystore
+	}
+
+	w, err := NewWalletFromPrivateKeyStr(hex.EncodeToString(plaintext))
+	if err != nil {
+		return nil, err
+	}
+	if w.BlockchainAddress() != ks.Address {
+		return nil, ErrDecryptKeystore
+	}
+	return w, nil
+}
+
+// ExportFile writes the encrypted keystore to path, readable only by its
+// owner.
+func (w *Wallet) ExportFile(path string, passphrase string) error {
+	data, err := w.Export(passphrase)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+func ImportFile(path string, passphrase string) (*Wallet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Import(data, passphrase)
+}
+
+func newKeystoreAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,61 @@
+package wallet
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestKeystoreRoundTrip(t *testing.T) {
+	w := NewWallet()
+	data, err := w.Export("passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Import(data, "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.PrivateKeyStr() != w.PrivateKeyStr() || got.BlockchainAddress() != w.BlockchainAddress() {
+		t.Errorf("imported wallet %s differs from %s", got.BlockchainAddress(), w.BlockchainAddress())
+	}
+
+	if _, err := Import(data, "wrong"); !errors.Is(err, ErrDecryptKeystore) {
+		t.Errorf("Import with wrong passphrase = %v, want %v", err, ErrDecryptKeystore)
+	}
+}
+
+func TestKeystoreScryptLimits(t *testing.T) {
+	data, err := NewWallet().Export("passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		n, r, p int
+	}{
+		{"n too large", 1 << 30, 8, 1},
+		{"n zero", 0, 8, 1},
+		{"r*p too large", 1 << 10, 1 << 20, 1 << 20},
+		{"r*p overflow", 1 << 10, math.MaxInt32, math.MaxInt32},
+		{"r negative", 1 << 10, -8, -1},
+		{"memory too large", 1 << 20, 16, 1},
+	}
+	for _, tt := range tests {
+		var ks keystoreJSON
+		if err := json.Unmarshal(data, &ks); err != nil {
+			t.Fatal(err)
+		}
+		ks.Crypto.KDFParams.N = tt.n
+		ks.Crypto.KDFParams.R = tt.r
+		ks.Crypto.KDFParams.P = tt.p
+		crafted, _ := json.Marshal(ks)
+
+		if _, err := Import(crafted, "passphrase"); err == nil || errors.Is(err, ErrDecryptKeystore) {
+			t.Errorf("%s: Import = %v, want a parameter error", tt.name, err)
+		}
+	}
+}
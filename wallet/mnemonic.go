@@ -0,0 +1,133 @@
+package wallet
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	_ "embed"
+	"errors"
+	"math/big"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	MNEMONIC_ENTROPY_BITS      = 128
+	MNEMONIC_SEED_ITERATIONS   = 2048
+	MNEMONIC_SEED_SALT_PREFIX  = "mnemonic"
+	MNEMONIC_SEED_LENGTH_BYTES = 64
+)
+
+var ErrInvalidMnemonic = errors.New("invalid mnemonic")
+
+// The BIP-39 English word list.
+//
+//go:embed wordlists/english.txt
+var englishWordlist string
+
+var (
+	wordlist     = strings.Fields(englishWordlist)
+	wordIndexes  = indexWords(wordlist)
+	elevenBits   = big.NewInt(2047)
+	twoToEleven  = big.NewInt(2048)
+	checksumMask = []byte{0x00, 0x80, 0xc0, 0xe0, 0xf0, 0xf8, 0xfc, 0xfe, 0xff}
+)
+
+func indexWords(words []string) map[string]int {
+	indexes := make(map[string]int, len(words))
+	for i, w := range words {
+		indexes[w] = i
+	}
+	return indexes
+}
+
+// NewMnemonic returns a BIP-39 phrase for bits of fresh entropy. bits must
+// be a multiple of 32 between 128 and 256, giving 12 to 24 words.
+func NewMnemonic(bits int) (string, error) {
+	if bits%32 != 0 || bits < 128 || bits > 256 {
+		return "", errors.New("entropy must be 128 to 256 bits in steps of 32")
+	}
+
+	entropy := make([]byte, bits/8)
+	if _, err := rand.Read(entropy); err != nil {
+		return "", err
+	}
+	return EntropyToMnemonic(entropy)
+}
+
+// EntropyToMnemonic appends the first len(entropy)*8/32 bits of the
+// entropy's SHA-256 as a checksum and spells the result 11 bits per word.
+func EntropyToMnemonic(entropy []byte) (string, error) {
+	bits := len(entropy) * 8
+	if bits%32 != 0 || bits < 128 || bits > 256 {
+		return "", errors.New("entropy must be 128 to 256 bits in steps of 32")
+	}
+
+	checksumBits := bits / 32
+	h := sha256.Sum256(entropy)
+
+	b := new(big.Int).SetBytes(entropy)
+	b.Lsh(b, uint(checksumBits))
+	b.Or(b, big.NewInt(int64(h[0]>>(8-checksumBits))))
+
+	count := (bits + checksumBits) / 11
+	words := make([]string, count)
+	word := new(big.Int)
+	for i := count - 1; i >= 0; i-- {
+		word.And(b, elevenBits)
+		b.Rsh(b, 11)
+		words[i] = wordlist[word.Int64()]
+	}
+	return strings.Join(words, " "), nil
+}
+
+// MnemonicToEntropy reverses EntropyToMnemonic, rejecting unknown words and
+// phrases whose checksum does not match.
+func MnemonicToEntropy(mnemonic string) ([]byte, error) {
+	words := strings.Fields(mnemonic)
+	if len(words)%3 != 0 || len(words) < 12 || len(words) > 24 {
+		return nil, ErrInvalidMnemonic
+	}
+
+	b := new(big.Int)
+	for _, w := range words {
+		i, ok := wordIndexes[w]
+		if !ok {
+			return nil, ErrInvalidMnemonic
+		}
+		b.Mul(b, twoToEleven)
+		b.Or(b, big.NewInt(int64(i)))
+	}
+
+	checksumBits := len(words) * 11 / 33
+	checksum := byte(new(big.Int).And(b, big.NewInt(int64(1)<<checksumBits-1)).Int64())
+	b.Rsh(b, uint(checksumBits))
+
+	entropy := make([]byte, checksumBits*4)
+	b.FillBytes(entropy)
+
+	h := sha256.Sum256(entropy)
+	if h[0]&checksumMask[checksumBits] != checksum<<(8-checksumBits) {
+		return nil, ErrInvalidMnemonic
+	}
+	return entropy, nil
+}
+
+func ValidateMnemonic(mnemonic string) bool {
+	_, err := MnemonicToEntropy(mnemonic)
+	return err == nil
+}
+
+// MnemonicToSeed stretches a valid mnemonic and an optional passphrase into
+// the 64 byte seed used for key derivation. Passphrases are used as given,
+// without Unicode normalization.
+func MnemonicToSeed(mnemonic string, passphrase string) ([]byte, error) {
+	if !ValidateMnemonic(mnemonic) {
+		return nil, ErrInvalidMnemonic
+	}
+
+	normalized := strings.Join(strings.Fields(mnemonic), " ")
+	return pbkdf2.Key([]byte(normalized), []byte(MNEMONIC_SEED_SALT_PREFIX+passphrase),
+		MNEMONIC_SEED_ITERATIONS, MNEMONIC_SEED_LENGTH_BYTES, sha512.New), nil
+}
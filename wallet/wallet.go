@@ -5,10 +5,17 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
 	"strings"
 
+	"github.com/Ethical-Ralph/go-block/amount"
+	"github.com/Ethical-Ralph/go-block/transaction"
 	"github.com/Ethical-Ralph/go-block/utils"
 	"github.com/btcsuite/btcutil/base58"
 	"golang.org/x/crypto/ripemd160"
@@ -26,26 +33,46 @@ type Transaction struct {
 	SenderBlockchainAddress    string
 	RecipientBlockchainAddress string
 
-	Value float32
+	Value     amount.Amount
+	Fee       amount.Amount
+	Nonce     uint64
+	Timestamp int64
+
+	// Inputs and Change are set once coins are selected, before signing.
+	Inputs []transaction.OutPoint
+	Change amount.Amount
 }
 type TransactionRequest struct {
 	SenderPrivateKey           *string `json:"sender_private_key"`
 	RecipientBlockchainAddress *string `json:"recipient_blockchain_address"`
 	SenderBlockchainAddress    *string `json:"sender_blockchain_address"`
 	Amount                     *string `json:"amount"`
+	Fee                        *string `json:"fee"`
 	SenderPublicKey            *string `json:"sender_public_key"`
 }
 
 func NewWallet() *Wallet {
 	// 1. creating ECDA private key (32 bytes) public key (64 bytes)
-	w := new(Wallet)
 	privateKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	return NewWalletFromPrivateKey(privateKey)
+}
+
+func NewWalletFromPrivateKey(privateKey *ecdsa.PrivateKey) *Wallet {
+	w := new(Wallet)
 	w.privateKey = privateKey
 	w.publicKey = &w.privateKey.PublicKey
+	w.blockchainAddress = AddressFromPublicKey(w.publicKey)
+	return w
+}
+
+// AddressFromPublicKey derives the blockchain address owned by publicKey.
+// Wallets use it to name themselves and the blockchain uses it to check that
+// a transaction's sender matches the key that signed it.
+func AddressFromPublicKey(publicKey *ecdsa.PublicKey) string {
 	// 2. Perform SHA-256 hashing on the public key (32 bytes)
 	h2 := sha256.New()
-	h2.Write(w.privateKey.X.Bytes())
-	h2.Write(w.privateKey.Y.Bytes())
+	h2.Write(publicKey.X.Bytes())
+	h2.Write(publicKey.Y.Bytes())
 	digest2 := h2.Sum(nil)
 	// 3. perform RIPEMD-160 hashing on the result of SHA-256 (20 bytes)
 	h3 := ripemd160.New()
@@ -55,24 +82,75 @@ func NewWallet() *Wallet {
 	vd4 := make([]byte, 21)
 	vd4[0] = 0x00
 	copy(vd4[1:], digest3[:])
-	// 5. Perform SHA-256 hash on the extended RIPEMD-160 result
-	h5 := sha256.New()
-	h5.Write(vd4)
-	digest5 := h5.Sum(nil)
-	// 6. perform SHA-256 hash on the result of the previous SHA-256 hash
-	h6 := sha256.New()
-	h6.Write(digest5)
-	digest6 := h5.Sum(nil)
-	// 7. take the first 4 bytes of the second SHA-256 hash for checksum
-	chSum := digest6[:4]
+	// 5-7. take the first 4 bytes of a SHA-256 of the result for checksum
+	chSum := addressChecksum(vd4)
 	// 8. add the 4 checksum bytes from 7 at the end of the extended RIPEND-160 hash from 4 (25 bytes)
 	dc8 := make([]byte, 25)
 	copy(dc8[:21], vd4[:])
 	copy(dc8[21:], chSum[:])
 	// 9. convert the result from byte string into base58
 	address := base58.Encode(dc8)
-	w.blockchainAddress = address
-	return w
+	return address
+}
+
+// NewWalletFromPrivateKeyStr rebuilds a wallet from the hex private key
+// returned by PrivateKeyStr.
+func NewWalletFromPrivateKeyStr(s string) (*Wallet, error) {
+	d, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	curve := elliptic.P256()
+	k := new(big.Int).SetBytes(d)
+	if k.Sign() == 0 || k.Cmp(curve.Params().N) >= 0 {
+		return nil, errors.New("private key out of range")
+	}
+
+	privateKey := new(ecdsa.PrivateKey)
+	privateKey.Curve = curve
+	privateKey.D = k
+	privateKey.X, privateKey.Y = curve.ScalarBaseMult(k.Bytes())
+	return NewWalletFromPrivateKey(privateKey), nil
+}
+
+// LoadWallet reads a wallet saved with Save.
+func LoadWallet(path string) (*Wallet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var v struct {
+		PrivateKey        string `json:"privateKey"`
+		BlockchainAddress string `json:"blockchainAddress"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+
+	w, err := NewWalletFromPrivateKeyStr(v.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	if w.BlockchainAddress() != v.BlockchainAddress {
+		return nil, fmt.Errorf("%s: address does not match private key", path)
+	}
+	return w, nil
+}
+
+// Save writes the wallet, private key included, to path. The file is only
+// readable by its owner.
+func (w *Wallet) Save(path string) error {
+	m, err := w.MarshalJSON()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, m, 0600)
 }
 
 func (w *Wallet) PrivateKey() *ecdsa.PrivateKey {
@@ -107,8 +185,9 @@ func (w *Wallet) MarshalJSON() ([]byte, error) {
 	})
 }
 
-func NewTransaction(privateKey *ecdsa.PrivateKey, publicKey *ecdsa.PublicKey, sender string, recipient string, value float32) *Transaction {
-	return &Transaction{privateKey, publicKey, sender, recipient, value}
+func NewTransaction(privateKey *ecdsa.PrivateKey, publicKey *ecdsa.PublicKey, sender string, recipient string,
+	value amount.Amount, fee amount.Amount, nonce uint64, timestamp int64) *Transaction {
+	return &Transaction{privateKey, publicKey, sender, recipient, value, fee, nonce, timestamp, nil, 0}
 }
 
 func (t *Transaction) GenerateSignature() *utils.Signature {
@@ -122,20 +201,19 @@ func (t *Transaction) Print() {
 	fmt.Printf("%s\n", strings.Repeat("-", 40))
 }
 
+// MarshalJSON produces exactly the payload the blockchain verifies the
+// signature against.
 func (t *Transaction) MarshalJSON() ([]byte, error) {
-	return json.Marshal(struct {
-		SenderBlockchainAddress    string  `json:"senderBlockchainAddress"`
-		RecipientBlockchainAddress string  `json:"recipientBlockchainAddress"`
-		Value                      float32 `json:"value"`
-	}{
-		SenderBlockchainAddress:    t.SenderBlockchainAddress,
-		RecipientBlockchainAddress: t.RecipientBlockchainAddress,
-		Value:                      t.Value,
-	})
+	tx := transaction.NewTransaction(t.SenderBlockchainAddress, t.RecipientBlockchainAddress,
+		t.Value, t.Fee, t.Nonce, t.Timestamp)
+	tx.Inputs = t.Inputs
+	tx.Change = t.Change
+	return tx.SigningPayload()
 }
 
 func (tr *TransactionRequest) Validate() bool {
-	if tr.SenderPrivateKey == nil || tr.RecipientBlockchainAddress == nil || tr.SenderBlockchainAddress == nil || tr.Amount == nil {
+	if tr.SenderPrivateKey == nil || tr.RecipientBlockchainAddress == nil || tr.SenderBlockchainAddress == nil || tr.Amount == nil ||
+		tr.SenderPublicKey == nil {
 		return false
 	}
 	return true
@@ -3,15 +3,20 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"strconv"
 	"text/template"
+	"time"
 
+	"github.com/Ethical-Ralph/go-block/amount"
 	"github.com/Ethical-Ralph/go-block/blockchain"
+	"github.com/Ethical-Ralph/go-block/transaction"
 	"github.com/Ethical-Ralph/go-block/utils"
+	"github.com/Ethical-Ralph/go-block/utxo"
 	"github.com/Ethical-Ralph/go-block/wallet"
 )
 
@@ -56,6 +61,66 @@ func (ws *WalletServer) Wallet(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// HDWallet creates a wallet from a fresh mnemonic, or restores the wallet at
+// index from a given mnemonic and passphrase.
+func (ws *WalletServer) HDWallet(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodPost:
+		w.Header().Add("Content-Type", "application/json")
+
+		var hr struct {
+			Mnemonic   string `json:"mnemonic"`
+			Passphrase string `json:"passphrase"`
+			Index      uint32 `json:"index"`
+		}
+		if req.ContentLength != 0 {
+			if err := json.NewDecoder(req.Body).Decode(&hr); err != nil {
+				log.Println(err)
+				w.WriteHeader(http.StatusBadRequest)
+				io.WriteString(w, string(utils.JsonStatus("failed")))
+				return
+			}
+		}
+
+		var hd *wallet.HDWallet
+		var err error
+		if hr.Mnemonic == "" {
+			hd, err = wallet.NewHDWallet(hr.Passphrase)
+		} else {
+			hd, err = wallet.RestoreHDWallet(hr.Mnemonic, hr.Passphrase)
+		}
+		if err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatus("failed: "+err.Error())))
+			return
+		}
+
+		myWallet, err := hd.Wallet(hr.Index)
+		if err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatus("failed: "+err.Error())))
+			return
+		}
+
+		m, _ := json.Marshal(struct {
+			Mnemonic string         `json:"mnemonic"`
+			Path     string         `json:"path"`
+			Wallet   *wallet.Wallet `json:"wallet"`
+		}{
+			Mnemonic: hd.Mnemonic(),
+			Path:     fmt.Sprintf("%s/%d", wallet.HD_DEFAULT_PATH, hr.Index),
+			Wallet:   myWallet,
+		})
+		io.WriteString(w, string(m))
+
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		log.Println("Invalid request method:", req.Method)
+	}
+}
+
 func (ws *WalletServer) CreateTransaction(w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case http.MethodPost:
@@ -74,20 +139,50 @@ func (ws *WalletServer) CreateTransaction(w http.ResponseWriter, req *http.Reque
 			return
 		}
 
-		publicKey := utils.PublicKeyFromString(*t.SenderPublicKey)
+		if err := wallet.ValidateAddress(*t.RecipientBlockchainAddress); err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonError("failed: invalid recipient address", "invalid_address")))
+			return
+		}
+		if err := wallet.ValidateAddress(*t.SenderBlockchainAddress); err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonError("failed: invalid sender address", "invalid_address")))
+			return
+		}
+
+		publicKey, err := utils.PublicKeyFromString(*t.SenderPublicKey)
+		if err != nil {
+			log.Println(err)
+			io.WriteString(w, string(utils.JsonStatus("failed: malformed public key")))
+			return
+		}
+
 		privateKey := utils.PrivateKeyFromString(*t.SenderPrivateKey, publicKey)
-		value, err := strconv.ParseFloat(*t.Amount, 32)
+		value, err := amount.Parse(*t.Amount)
 
 		if err != nil {
 			log.Println(err)
-			io.WriteString(w, string(utils.JsonStatus("failed")))
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonError("failed: invalid amount", "invalid_amount")))
 			return
 		}
 
-		value32 := float32(value)
+		var fee amount.Amount = 0
+		if t.Fee != nil && *t.Fee != "" {
+			fee, err = amount.Parse(*t.Fee)
+			if err != nil {
+				log.Println(err)
+				w.WriteHeader(http.StatusBadRequest)
+				io.WriteString(w, string(utils.JsonError("failed: invalid fee", "invalid_fee")))
+				return
+			}
+		}
+
 		// fmt.Println(privateKey)
 		// fmt.Println(publicKey)
-		// fmt.Printf("%.1f\n", value32)
+		// fmt.Printf("%s\n", value)
 
 		// fmt.Println(*t.RecipientBlockchainAddress)
 		// fmt.Println(*t.SenderBlockchainAddress)
@@ -96,18 +191,46 @@ func (ws *WalletServer) CreateTransaction(w http.ResponseWriter, req *http.Reque
 
 		w.Header().Add("Content-Type", "application/json")
 
-		transaction := wallet.NewTransaction(privateKey, publicKey, *t.SenderBlockchainAddress, *t.RecipientBlockchainAddress, value32)
+		nonce, err := ws.NextNonce(*t.SenderBlockchainAddress)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			io.WriteString(w, string(utils.JsonStatus(err.Error())))
+			return
+		}
+		timestamp := time.Now().UnixNano()
+
+		inputs, change, err := ws.SelectInputs(*t.SenderBlockchainAddress, value, fee)
+		if errors.Is(err, utxo.ErrInsufficientInputs) {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			io.WriteString(w, string(utils.JsonError("insufficient funds", "insufficient_funds")))
+			return
+		}
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			io.WriteString(w, string(utils.JsonStatus(err.Error())))
+			return
+		}
+
+		transaction := wallet.NewTransaction(privateKey, publicKey, *t.SenderBlockchainAddress, *t.RecipientBlockchainAddress,
+			value, fee, nonce, timestamp)
+		transaction.Inputs = inputs
+		transaction.Change = change
 
 		signature := transaction.GenerateSignature()
 
 		signatureStr := signature.String()
 
 		bt := &blockchain.TransactionRequest{
-			t.SenderBlockchainAddress,
-			t.RecipientBlockchainAddress,
-			t.SenderPublicKey,
-			&value32,
-			&signatureStr,
+			SenderBlockchainAddress:    t.SenderBlockchainAddress,
+			RecipientBlockchainAddress: t.RecipientBlockchainAddress,
+			SenderPublicKey:            t.SenderPublicKey,
+			Value:                      &value,
+			Fee:                        &fee,
+			Change:                     &change,
+			Inputs:                     &inputs,
+			Nonce:                      &nonce,
+			Timestamp:                  &timestamp,
+			Signature:                  &signatureStr,
 		}
 
 		m, _ := json.Marshal(bt)
@@ -122,12 +245,26 @@ func (ws *WalletServer) CreateTransaction(w http.ResponseWriter, req *http.Reque
 			return
 		}
 
+		defer resp.Body.Close()
+
 		if resp.StatusCode == 201 {
 			io.WriteString(w, string(utils.JsonStatus("success")))
 			return
 		}
 
-		io.WriteString(w, string(utils.JsonStatus("fail")))
+		// Relay the gateway's reason, e.g. "insufficient funds", so the UI
+		// can show it instead of a generic failure.
+		var status struct {
+			Message string `json:"message"`
+			Reason  string `json:"reason"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&status); err != nil || status.Message == "" {
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+
+		w.WriteHeader(resp.StatusCode)
+		io.WriteString(w, string(utils.JsonError(status.Message, status.Reason)))
 
 	default:
 		w.WriteHeader(http.StatusBadRequest)
@@ -135,6 +272,75 @@ func (ws *WalletServer) CreateTransaction(w http.ResponseWriter, req *http.Reque
 	}
 }
 
+// NextNonce asks the gateway which nonce the next transaction from
+// blockchainAddress must carry.
+func (ws *WalletServer) NextNonce(blockchainAddress string) (uint64, error) {
+	endpoint := fmt.Sprintf("%s/nonce", ws.Gateway())
+
+	bcsReq, _ := http.NewRequest("GET", endpoint, nil)
+	q := bcsReq.URL.Query()
+	q.Add("blockchain_address", blockchainAddress)
+	bcsReq.URL.RawQuery = q.Encode()
+
+	bcsResp, err := http.DefaultClient.Do(bcsReq)
+	if err != nil {
+		return 0, err
+	}
+	defer bcsResp.Body.Close()
+
+	if bcsResp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("gateway returned %s for nonce", bcsResp.Status)
+	}
+
+	var nr blockchain.NonceResponse
+	if err := json.NewDecoder(bcsResp.Body).Decode(&nr); err != nil {
+		return 0, err
+	}
+	return nr.Nonce, nil
+}
+
+// SelectInputs picks outputs of blockchainAddress that cover value plus
+// fee and returns them with the change that goes back to the sender.
+func (ws *WalletServer) SelectInputs(blockchainAddress string, value amount.Amount, fee amount.Amount) (
+	[]transaction.OutPoint, amount.Amount, error) {
+	target, err := value.Add(fee)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	endpoint := fmt.Sprintf("%s/utxos", ws.Gateway())
+
+	bcsReq, _ := http.NewRequest("GET", endpoint, nil)
+	q := bcsReq.URL.Query()
+	q.Add("blockchain_address", blockchainAddress)
+	bcsReq.URL.RawQuery = q.Encode()
+
+	bcsResp, err := http.DefaultClient.Do(bcsReq)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer bcsResp.Body.Close()
+
+	if bcsResp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("gateway returned %s for utxos", bcsResp.Status)
+	}
+
+	var ur blockchain.UTXOResponse
+	if err := json.NewDecoder(bcsResp.Body).Decode(&ur); err != nil {
+		return nil, 0, err
+	}
+
+	selected, total, err := utxo.Select(ur.Outputs, target)
+	if err != nil {
+		return nil, 0, err
+	}
+	inputs := make([]transaction.OutPoint, len(selected))
+	for i, e := range selected {
+		inputs[i] = e.OutPoint
+	}
+	return inputs, total - target, nil
+}
+
 func (ws *WalletServer) WalletAmount(w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case http.MethodGet:
@@ -170,11 +376,15 @@ func (ws *WalletServer) WalletAmount(w http.ResponseWriter, req *http.Request) {
 			}
 
 			m, _ := json.Marshal(struct {
-				Message string  `json:"message"`
-				Amount  float32 `json:"amount"`
+				Message   string        `json:"message"`
+				Amount    amount.Amount `json:"amount"`
+				Confirmed amount.Amount `json:"confirmed"`
+				Pending   amount.Amount `json:"pending"`
 			}{
-				Message: "success",
-				Amount:  bar.Amount,
+				Message:   "success",
+				Amount:    bar.Amount,
+				Confirmed: bar.Confirmed,
+				Pending:   bar.Pending,
 			})
 
 			io.WriteString(w, string(m[:]))
@@ -192,6 +402,7 @@ func (ws *WalletServer) WalletAmount(w http.ResponseWriter, req *http.Request) {
 func (ws *WalletServer) Run() {
 	http.HandleFunc("/", ws.Index)
 	http.HandleFunc("/wallet", ws.Wallet)
+	http.HandleFunc("/wallet/hd", ws.HDWallet)
 	http.HandleFunc("/wallet/amount", ws.WalletAmount)
 	http.HandleFunc("/transaction", ws.CreateTransaction)
 	log.Fatal(http.ListenAndServe("0.0.0.0:"+strconv.Itoa(int(ws.Port())), nil))